@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/mailer"
+)
+
+// handlerDeleteAccount lets users delete their own account.  The account and
+// its chirps are soft-deleted, so the user can undo it through
+// POST /api/users/restore until the purger removes them for good.
+func (cfg *apiConfig) handlerDeleteAccount(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		wrt.WriteHeader(401)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := deleteAccountParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Re-confirming the password guards against a stolen access token, so
+	// guesses count towards the login lockout
+	if !cfg.checkLoginAllowed(wrt, req, user.Email) {
+		return
+	}
+	if _, err := cfg.verifyPassword(params.Password, user.HashedPassword); err != nil {
+		cfg.loginFailed(wrt, req, user.Email)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	deleted, err := softDeleteUser(req.Context(), cfg.withTx(tx), user.ID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting user", "user_id", user.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing account deletion", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	restoreBefore := deleted.DeletedAt.Time.Add(cfg.deletionGracePeriod)
+	ctx := context.WithoutCancel(req.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := cfg.sendAccountDeletedEmail(ctx, deleted, restoreBefore); err != nil {
+			slog.ErrorContext(ctx, "Error sending account deletion email", "error", err)
+		}
+	}()
+
+	respondWithJSON(wrt, 200, accountDeletion{
+		DeletedAt:     deleted.DeletedAt.Time,
+		RestoreBefore: restoreBefore,
+	})
+}
+
+func (cfg *apiConfig) sendAccountDeletedEmail(ctx context.Context, user database.User, restoreBefore time.Time) error {
+	body := fmt.Sprintf("Your Chirpy account has been deleted and you have been signed out everywhere.\n\nIf you change your mind, you can restore it with your email and password through POST /api/users/restore until %s.  After that your account and chirps will be permanently removed.\n", restoreBefore.UTC().Format(time.RFC1123))
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account has been deleted",
+		Body:    body,
+	})
+}
+
+// handlerRestoreAccount undoes a self-deletion within the grace period and
+// signs the user back in.
+func (cfg *apiConfig) handlerRestoreAccount(wrt http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	params := userParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	if !cfg.checkLoginAllowed(wrt, req, params.Email) {
+		return
+	}
+	user, err := cfg.db.GetDeletedUserByEmail(req.Context(), params.Email)
+	if err != nil {
+		cfg.hashers.Verify(params.Password, cfg.dummyHash)
+		cfg.loginFailed(wrt, req, params.Email)
+		return
+	}
+	if _, err := cfg.verifyPassword(params.Password, user.HashedPassword); err != nil {
+		cfg.loginFailed(wrt, req, params.Email)
+		return
+	}
+	if !cfg.restorable(user.DeletedAt) {
+		respondWithError(wrt, 410, "Account can no longer be restored")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	user, err = restoreUser(req.Context(), cfg.withTx(tx), user)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error restoring user", "user_id", user.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing account restore", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	cfg.completeLogin(wrt, req, user)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/mailer"
+)
+
+func TestSendAccountDeletedEmail(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cfg := &apiConfig{mailer: mailer.NewLogMailer(buf, "no-reply@chirpy.local")}
+
+	restoreBefore := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	err := cfg.sendAccountDeletedEmail(context.Background(), database.User{Email: "saul@bettercall.com"}, restoreBefore)
+	if err != nil {
+		t.Fatalf("error sending email: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "To: saul@bettercall.com\r\n") {
+		t.Errorf("email was not addressed to the user: %q", out)
+	}
+	if !strings.Contains(out, "POST /api/users/restore") || !strings.Contains(out, "Sat, 01 Mar 2025 12:00:00 UTC") {
+		t.Errorf("email does not explain how and until when to restore: %q", out)
+	}
+}
+
+func TestSoftDeleteUserRevokesCredentials(t *testing.T) {
+	userID := uuid.New()
+	deleted := userRow(userID, "saul@bettercall.com", true, roleUser)
+	deleted[7] = time.Now()
+	db := newFakeDB(map[string][]driver.Value{"SoftDeleteUser": deleted})
+
+	if _, err := softDeleteUser(context.Background(), db.queries(), userID); err != nil {
+		t.Fatalf("error deleting user: %v", err)
+	}
+	for _, name := range []string{"RevokeAllRefreshTokensForUser", "RevokeAllAPIKeysForUser", "RevokeAllOAuthRefreshTokensForUser"} {
+		if !db.called(name) {
+			t.Errorf("expected %s to run", name)
+		}
+	}
+}
+
+func TestDeletedAccountCredentialsStopWorking(t *testing.T) {
+	userID := uuid.New()
+	// GetUser leaves out deleted accounts, so it finds nothing
+	cfg := newFakeDB(map[string][]driver.Value{
+		"GetAPIKeyByHash": apiKeyRow(uuid.New(), userID, "{chirps:read,chirps:write}"),
+	}).apiConfig()
+
+	handler := cfg.middlewareAuth(scopeChirpsWrite, func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(204)
+	})
+	req := httptest.NewRequest("POST", "/api/chirps/"+uuid.NewString()+"/report", nil)
+	req.Header.Set("Authorization", "ApiKey chirpy_abcd1234_secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("expected a deleted account's API key to be refused, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.issueOAuthTokens(rec, httptest.NewRequest("POST", "/oauth/token", nil), "client", userID, []string{scopeChirpsRead})
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "invalid_grant") {
+		t.Errorf("expected no OAuth tokens for a deleted account, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDeletedAccountAccessTokenStopsWorking(t *testing.T) {
+	// GetUser leaves out deleted accounts, so it finds nothing
+	db := newFakeDB(map[string][]driver.Value{})
+	cfg := db.apiConfig()
+
+	req := requestAs(t, cfg, uuid.New(), "PUT", "/api/users", `{"email": "saul@bettercall.com", "password": "Kettleman2008"}`)
+	rec := httptest.NewRecorder()
+	cfg.handlerUpdateUser(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("expected a deleted account's access token to be refused, got %d", rec.Code)
+	}
+	if db.called("UpdateUser") {
+		t.Error("expected a deleted account to be left unchanged")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// Scopes that can be granted to API keys and third-party OAuth clients.  Access
+// tokens from a first-party login hold all of them.
+const (
+	scopeChirpsRead  = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+)
+
+var grantableScopes = []string{scopeChirpsRead, scopeChirpsWrite}
+
+// principal is whoever authenticated a request: a user via an access token,
+// or a user's API key or a third-party OAuth client limited to some scopes.
+type principal struct {
+	UserID   uuid.UUID
+	APIKeyID uuid.NullUUID
+	ClientID string
+	// Scopes is nil for first-party access tokens, which may do anything
+	Scopes []string
+}
+
+func (p principal) hasScope(scope string) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// missingScopeError is returned for valid credentials that lack a scope the
+// request needs.
+type missingScopeError struct {
+	scope string
+}
+
+func (e *missingScopeError) Error() string {
+	return fmt.Sprintf("API key is missing the %s scope", e.scope)
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey{}).(principal)
+	return p
+}
+
+// middlewareAuth requires a Bearer access token or an ApiKey holding scope,
+// and makes the principal available through principalFromContext.
+func (cfg *apiConfig) middlewareAuth(scope string, next http.HandlerFunc) http.Handler {
+	f := func(wrt http.ResponseWriter, req *http.Request) {
+		p, err := cfg.authenticatePrincipal(req)
+		if err == nil {
+			err = cfg.checkActive(req.Context(), p.UserID)
+		}
+		if err != nil {
+			respondWithAuthError(wrt, err)
+			return
+		}
+		setRequestUser(req.Context(), p.UserID)
+		if !p.hasScope(scope) {
+			respondWithAuthError(wrt, &missingScopeError{scope: scope})
+			return
+		}
+		next.ServeHTTP(wrt, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+	}
+	return http.HandlerFunc(f)
+}
+
+func (cfg *apiConfig) authenticatePrincipal(req *http.Request) (principal, error) {
+	key, err := auth.GetAPIKey(req.Header)
+	if err != nil {
+		tokenString, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			return principal{}, err
+		}
+		if userId, err := auth.ValidateJWT(tokenString, cfg.secret); err == nil {
+			return principal{UserID: userId}, nil
+		}
+		return cfg.authenticateOAuthToken(req.Context(), tokenString)
+	}
+
+	apiKey, err := cfg.db.GetAPIKeyByHash(req.Context(), auth.HashToken(key))
+	if err != nil {
+		return principal{}, err
+	}
+	if err := cfg.db.TouchAPIKey(req.Context(), apiKey.ID); err != nil {
+		slog.ErrorContext(req.Context(), "Error updating last use of API key", "api_key_id", apiKey.ID, "error", err)
+	}
+	return principal{
+		UserID:   apiKey.UserID,
+		APIKeyID: uuid.NullUUID{UUID: apiKey.ID, Valid: true},
+		Scopes:   apiKey.Scopes,
+	}, nil
+}
+
+func apiKeyResponse(apiKey database.ApiKey) APIKey {
+	key := APIKey{
+		ID:        apiKey.ID,
+		CreatedAt: apiKey.CreatedAt,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		Scopes:    apiKey.Scopes,
+	}
+	if apiKey.LastUsedAt.Valid {
+		key.LastUsedAt = &apiKey.LastUsedAt.Time
+	}
+	return key
+}
+
+// API keys are managed with an access token only, so a leaked key can't mint more keys.
+
+func (cfg *apiConfig) handlerCreateAPIKey(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := apiKeyParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(wrt, 400, "No name was supplied")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(wrt, 400, "No scopes were supplied")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(grantableScopes, scope) {
+			respondWithError(wrt, 400, fmt.Sprintf("Unknown scope %s", scope))
+			return
+		}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating API key", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(req.Context(), database.CreateAPIKeyParams{
+		UserID:  userId,
+		Name:    params.Name,
+		Prefix:  prefix,
+		KeyHash: auth.HashToken(key),
+		Scopes:  slices.Compact(slices.Sorted(slices.Values(params.Scopes))),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving API key", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	resp := apiKeyResponse(apiKey)
+	resp.Key = key
+	respondWithJSON(wrt, 201, resp)
+}
+
+func (cfg *apiConfig) handlerListAPIKeys(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	apiKeys, err := cfg.db.ListAPIKeysForUser(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing API keys", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	keys := make([]APIKey, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		keys[i] = apiKeyResponse(apiKey)
+	}
+	respondWithJSON(wrt, 200, keys)
+}
+
+func (cfg *apiConfig) handlerRevokeAPIKey(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	keyID, err := uuid.Parse(req.PathValue("keyID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("keyID")))
+		return
+	}
+	rows, err := cfg.db.RevokeAPIKey(req.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userId,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error revoking API key", "api_key_id", keyID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		respondWithError(wrt, 404, fmt.Sprintf("No API key found for id %v", keyID))
+		return
+	}
+	wrt.WriteHeader(204)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Errorf("error making API key: %v", err)
+	}
+	if len(prefix) != 8 || !strings.HasPrefix(key, "chirpy_"+prefix+"_") {
+		t.Errorf("key %s does not start with its prefix %s", key, prefix)
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	headers := make(http.Header)
+	headers.Set("Authorization", "ApiKey chirpy_abcd1234_secret")
+
+	key, err := auth.GetAPIKey(headers)
+	if err != nil || key != "chirpy_abcd1234_secret" {
+		t.Errorf("expected API key, got %q (err %v)", key, err)
+	}
+	if _, err := auth.GetBearerToken(headers); err == nil {
+		t.Errorf("an ApiKey header should not be returned as a bearer token")
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	session := principal{UserID: uuid.New()}
+	if !session.hasScope(scopeChirpsWrite) {
+		t.Errorf("access tokens should hold every scope")
+	}
+
+	key := principal{
+		UserID:   uuid.New(),
+		APIKeyID: uuid.NullUUID{UUID: uuid.New(), Valid: true},
+		Scopes:   []string{scopeChirpsRead},
+	}
+	if !key.hasScope(scopeChirpsRead) || key.hasScope(scopeChirpsWrite) {
+		t.Errorf("API key scopes were not respected")
+	}
+}
+
+func TestMiddlewareAuthWithJWT(t *testing.T) {
+	id := uuid.New()
+	cfg := &apiConfig{secret: "my_secret", db: newFakeDB(map[string][]driver.Value{
+		"GetUser": userRow(id, "saul@bettercall.com", true, roleUser),
+	}).queries()}
+	var got principal
+	handler := cfg.middlewareAuth(scopeChirpsWrite, func(wrt http.ResponseWriter, req *http.Request) {
+		got = principalFromContext(req.Context())
+		wrt.WriteHeader(204)
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/chirps", nil))
+	if rec.Code != 401 {
+		t.Errorf("expected 401 without credentials, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, requestAs(t, cfg, id, "POST", "/api/chirps", ""))
+	if rec.Code != 204 || got.UserID != id {
+		t.Errorf("expected JWT to authenticate user %v, got %d %+v", id, rec.Code, got)
+	}
+}
+
+// apiKeyRow is a GetAPIKeyByHash result for a key holding scopes
+func apiKeyRow(id, userID uuid.UUID, scopes string) []driver.Value {
+	return []driver.Value{id.String(), time.Now(), userID.String(), "test", "abcd1234", "hash", scopes, nil, nil}
+}
+
+func TestViewerRequiresReadScope(t *testing.T) {
+	userID := uuid.New()
+	for _, c := range []struct {
+		scopes string
+		want   int
+	}{
+		{"{chirps:read}", 204},
+		{"{chirps:write}", 403},
+	} {
+		cfg := &apiConfig{db: newFakeDB(map[string][]driver.Value{
+			"GetAPIKeyByHash": apiKeyRow(uuid.New(), userID, c.scopes),
+			"GetUser":         userRow(userID, "saul@bettercall.com", true, roleUser),
+		}).queries()}
+		req := httptest.NewRequest("GET", "/api/chirps", nil)
+		req.Header.Set("Authorization", "ApiKey chirpy_abcd1234_secret")
+
+		rec := httptest.NewRecorder()
+		viewer, err := cfg.viewer(req)
+		if err != nil {
+			respondWithAuthError(rec, err)
+		} else {
+			rec.WriteHeader(204)
+		}
+		if rec.Code != c.want {
+			t.Errorf("key with %s: expected %d, got %d", c.scopes, c.want, rec.Code)
+		}
+		if c.want == 204 && viewer.UUID != userID {
+			t.Errorf("expected the key's user as the viewer, got %v", viewer)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nfongster/chirpy/internal/auth"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -70,6 +73,17 @@ func TestGetBearerToken(t *testing.T) {
 	}
 }
 
+func TestGetBearerTokenErrorOmitsHeader(t *testing.T) {
+	// A refresh token sent without the Bearer prefix mustn't end up in logs
+	headers := make(http.Header)
+	headers.Set("Authorization", "iamasecret")
+
+	_, err := auth.GetBearerToken(headers)
+	if err == nil || strings.Contains(err.Error(), "iamasecret") {
+		t.Errorf("expected an error without the header value, got %v", err)
+	}
+}
+
 func TestMakeRefreshToken(t *testing.T) {
 	token, err := auth.MakeRefreshToken()
 	if err != nil {
@@ -79,3 +93,122 @@ func TestMakeRefreshToken(t *testing.T) {
 		t.Errorf("token was empty string!")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, _ := auth.MakeRefreshToken()
+
+	hash := auth.HashToken(token)
+	if hash == token || len(hash) != 64 {
+		t.Errorf("unexpected token hash %s", hash)
+	}
+	if auth.HashToken(token) != hash {
+		t.Errorf("hashing the same token twice gave different results")
+	}
+}
+
+func fastArgon2idHasher() *auth.Argon2idHasher {
+	return &auth.Argon2idHasher{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	hasher := fastArgon2idHasher()
+	hash, err := hasher.Hash("password")
+	if err != nil {
+		t.Errorf("error hashing password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash did not encode algorithm and parameters: %s", hash)
+	}
+	if err := hasher.Verify("password", hash); err != nil {
+		t.Errorf("failed to verify password: %v", err)
+	}
+	if err := hasher.Verify("password2", hash); !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+}
+
+func TestHashersRehashesLegacyBcrypt(t *testing.T) {
+	bc := auth.NewBcryptHasher(bcrypt.MinCost)
+	hashers := auth.NewHashers(fastArgon2idHasher(), bc)
+
+	legacyHash, _ := bc.Hash("password")
+	needsRehash, err := hashers.Verify("password", legacyHash)
+	if err != nil {
+		t.Errorf("failed to verify legacy hash: %v", err)
+	}
+	if !needsRehash {
+		t.Errorf("expected bcrypt hash to need rehashing")
+	}
+
+	newHash, _ := hashers.Hash("password")
+	needsRehash, err = hashers.Verify("password", newHash)
+	if err != nil || needsRehash {
+		t.Errorf("expected current hash to verify without rehash, got needsRehash=%v err=%v", needsRehash, err)
+	}
+}
+
+func TestHashersRehashesOutdatedParameters(t *testing.T) {
+	old := fastArgon2idHasher()
+	oldHash, _ := old.Hash("password")
+
+	current := fastArgon2idHasher()
+	current.Iterations = 2
+	needsRehash, err := auth.NewHashers(current).Verify("password", oldHash)
+	if err != nil {
+		t.Errorf("failed to verify hash with old parameters: %v", err)
+	}
+	if !needsRehash {
+		t.Errorf("expected hash with old parameters to need rehashing")
+	}
+
+	_, err = auth.NewHashers(current).Verify("password", "unset")
+	if !errors.Is(err, auth.ErrUnknownHash) {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+}
+
+func TestArgon2idRejectsCorruptHash(t *testing.T) {
+	hasher := fastArgon2idHasher()
+	hash, _ := hasher.Hash("password")
+	parts := strings.Split(hash, "$")
+
+	for _, corrupt := range []string{
+		strings.Replace(hash, "t=1", "t=0", 1),
+		strings.Replace(hash, "p=1", "p=0", 1),
+		strings.Join(append(parts[:5:5], ""), "$"),
+	} {
+		if err := hasher.Verify("password", corrupt); err == nil || errors.Is(err, auth.ErrPasswordMismatch) {
+			t.Errorf("expected an invalid hash error for %s, got %v", corrupt, err)
+		}
+	}
+}
+
+// countingHasher counts the hashes it is asked to verify
+type countingHasher struct {
+	auth.PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password, hash string) error {
+	h.verified++
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+func TestVerifyPasswordWithoutPasswordSpendsAHash(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: fastArgon2idHasher()}
+	dummyHash, _ := hasher.Hash("chirpy-dummy-password")
+	cfg := &apiConfig{hashers: auth.NewHashers(hasher), dummyHash: dummyHash}
+
+	if _, err := cfg.verifyPassword("password", unusablePasswordHash); !errors.Is(err, auth.ErrUnknownHash) {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+	if hasher.verified != 1 {
+		t.Errorf("expected an account without a password to cost one hash, got %d", hasher.verified)
+	}
+}
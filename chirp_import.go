@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	importBatchSize = 100
+	// importMaxBytes caps the size of an archive uploaded over HTTP
+	importMaxBytes     = 10 << 20
+	importMaxLineBytes = 64 << 10
+)
+
+const (
+	importStatusImported  = "imported"
+	importStatusDuplicate = "duplicate"
+	importStatusFailed    = "failed"
+)
+
+// pendingImport is a validated line waiting for its batch to be written
+type pendingImport struct {
+	result int
+	params database.ImportChirpParams
+}
+
+// importChirps reads a JSON Lines archive of chirps and adds them to the
+// user's timeline with their original timestamps.  Each line is checked on
+// its own and reported on in the returned report; lines that pass are
+// written in batches, one transaction per batch.  Chirps are deduplicated by
+// external ID, both within the archive and against earlier imports, so a
+// failed import can simply be run again.
+//
+// Lines longer than importMaxLineBytes are reported as failed and skipped.
+// The error is only set if the archive itself could not be read; the report
+// then covers the lines read up to that point.
+func (cfg *apiConfig) importChirps(ctx context.Context, userID uuid.UUID, r io.Reader) (chirpImportReport, error) {
+	report := chirpImportReport{Lines: []chirpImportResult{}}
+	seen := map[string]int{}
+	batch := make([]pendingImport, 0, importBatchSize)
+	now := time.Now()
+
+	reader := bufio.NewReaderSize(r, importMaxLineBytes)
+	lineNum := 0
+	var readErr error
+	for readErr == nil {
+		var data []byte
+		data, readErr = reader.ReadSlice('\n')
+		if len(data) == 0 && readErr != nil {
+			break
+		}
+		lineNum++
+
+		result := chirpImportResult{Line: lineNum}
+		if errors.Is(readErr, bufio.ErrBufferFull) {
+			// Skip the rest of the line
+			for errors.Is(readErr, bufio.ErrBufferFull) {
+				_, readErr = reader.ReadSlice('\n')
+			}
+			result.Status = importStatusFailed
+			result.Error = fmt.Sprintf("Line is longer than %d bytes", importMaxLineBytes)
+			report.Lines = append(report.Lines, result)
+			continue
+		}
+		data = bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+		if len(data) == 0 {
+			continue
+		}
+
+		var line chirpImportLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			result.Status = importStatusFailed
+			result.Error = fmt.Sprintf("Invalid JSON: %v", err)
+			report.Lines = append(report.Lines, result)
+			continue
+		}
+		result.ExternalID = line.ExternalID
+
+		if msg := validateImportLine(line, now); msg != "" {
+			result.Status = importStatusFailed
+			result.Error = msg
+			report.Lines = append(report.Lines, result)
+			continue
+		}
+		if first, ok := seen[line.ExternalID]; ok {
+			result.Status = importStatusDuplicate
+			result.Error = fmt.Sprintf("Duplicate of line %d", first)
+			report.Lines = append(report.Lines, result)
+			continue
+		}
+		seen[line.ExternalID] = lineNum
+
+		report.Lines = append(report.Lines, result)
+		batch = append(batch, pendingImport{
+			result: len(report.Lines) - 1,
+			params: database.ImportChirpParams{
+				CreatedAt: *line.CreatedAt,
+				Body:      removeProfanities(line.Body),
+				UserID: uuid.NullUUID{
+					UUID:  userID,
+					Valid: true,
+				},
+				ExternalID: sql.NullString{
+					String: line.ExternalID,
+					Valid:  true,
+				},
+			},
+		})
+		if len(batch) == importBatchSize {
+			cfg.writeImportBatch(ctx, batch, report.Lines)
+			batch = batch[:0]
+		}
+	}
+	cfg.writeImportBatch(ctx, batch, report.Lines)
+
+	for _, result := range report.Lines {
+		switch result.Status {
+		case importStatusImported:
+			report.Imported++
+		case importStatusDuplicate:
+			report.Duplicates++
+		default:
+			report.Failed++
+		}
+	}
+
+	if !errors.Is(readErr, io.EOF) {
+		return report, fmt.Errorf("reading line %d: %w", lineNum+1, readErr)
+	}
+	return report, nil
+}
+
+// validateImportLine returns why a line can't be imported, or "" if it can
+func validateImportLine(line chirpImportLine, now time.Time) string {
+	switch {
+	case line.ExternalID == "":
+		return "external_id is required"
+	case line.Body == "":
+		return "body is required"
+	case !validateChirp(line.Body):
+		return "Chirp is too long"
+	case line.CreatedAt == nil:
+		return "created_at is required"
+	case line.CreatedAt.After(now):
+		return "created_at is in the future"
+	}
+	return ""
+}
+
+// writeImportBatch inserts a batch in one transaction and fills in the
+// matching results.  If the transaction fails, every line in it is marked as
+// failed, since none of them were written.
+func (cfg *apiConfig) writeImportBatch(ctx context.Context, batch []pendingImport, results []chirpImportResult) {
+	if len(batch) == 0 {
+		return
+	}
+
+	fail := func(err error) {
+		slog.ErrorContext(ctx, "Error importing chirps", "error", err)
+		for _, p := range batch {
+			results[p.result].Status = importStatusFailed
+			results[p.result].ChirpID = nil
+			results[p.result].Error = "Could not save chirp"
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	for _, p := range batch {
+		chirp, err := qtx.ImportChirp(ctx, p.params)
+		if errors.Is(err, sql.ErrNoRows) {
+			results[p.result].Status = importStatusDuplicate
+			results[p.result].Error = "Already imported"
+			continue
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		results[p.result].Status = importStatusImported
+		results[p.result].ChirpID = &chirp.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		fail(err)
+		return
+	}
+	for _, p := range batch {
+		if results[p.result].Status == importStatusImported {
+			chirpsCreated.Inc()
+		}
+	}
+}
+
+// handlerImportChirps imports a JSON Lines archive posted as the request
+// body into the caller's timeline.
+func (cfg *apiConfig) handlerImportChirps(wrt http.ResponseWriter, req *http.Request) {
+	userId := principalFromContext(req.Context()).UserID
+
+	// Only verified accounts may post
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		wrt.WriteHeader(401)
+		return
+	}
+	if !user.EmailVerified {
+		respondWithError(wrt, 403, "Email address has not been verified")
+		return
+	}
+
+	body := http.MaxBytesReader(wrt, req.Body, importMaxBytes)
+	report, err := cfg.importChirps(req.Context(), userId, body)
+	if err != nil {
+		// Batches before the error were saved, so the client still needs the report
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithJSON(wrt, 413, chirpImportFailure{
+				Error:  fmt.Sprintf("Import is larger than %d bytes", importMaxBytes),
+				Report: report,
+			})
+			return
+		}
+		respondWithJSON(wrt, 400, chirpImportFailure{
+			Error:  fmt.Sprintf("Could not read import: %v", err),
+			Report: report,
+		})
+		return
+	}
+
+	respondWithJSON(wrt, 200, report)
+}
+
+// runImportCommand implements "chirpy import", which loads an archive into
+// a user's timeline from the command line.  The report is written to stdout
+// as JSON; the exit status is non-zero if any line failed.
+func runImportCommand(cfg *apiConfig, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	email := flags.String("email", "", "email address of the user who owns the chirps")
+	path := flags.String("file", "-", "JSON Lines file to import, or - for stdin")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" {
+		fmt.Fprintln(stderr, "usage: chirpy import -email <address> [-file <path>]")
+		return 2
+	}
+
+	ctx := context.Background()
+	user, err := cfg.db.GetUserByEmail(ctx, *email)
+	if err != nil {
+		fmt.Fprintf(stderr, "error finding user %s: %v\n", *email, err)
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if *path != "-" {
+		f, err := os.Open(*path)
+		if err != nil {
+			fmt.Fprintf(stderr, "error opening import: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := cfg.importChirps(ctx, user.ID, r)
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(stderr, "error writing report: %v\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading import: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "%d imported, %d duplicates, %d failed\n", report.Imported, report.Duplicates, report.Failed)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
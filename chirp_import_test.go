@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateImportLine(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-24 * time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := map[string]struct {
+		line chirpImportLine
+		want string
+	}{
+		"valid":               {chirpImportLine{ExternalID: "1", Body: "S'all good, man.", CreatedAt: &past}, ""},
+		"missing external id": {chirpImportLine{Body: "S'all good, man.", CreatedAt: &past}, "external_id is required"},
+		"missing body":        {chirpImportLine{ExternalID: "1", CreatedAt: &past}, "body is required"},
+		"too long":            {chirpImportLine{ExternalID: "1", Body: strings.Repeat("a", 141), CreatedAt: &past}, "Chirp is too long"},
+		"missing timestamp":   {chirpImportLine{ExternalID: "1", Body: "S'all good, man."}, "created_at is required"},
+		"future timestamp":    {chirpImportLine{ExternalID: "1", Body: "S'all good, man.", CreatedAt: &future}, "created_at is in the future"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := validateImportLine(tc.line, now); got != tc.want {
+				t.Errorf("validateImportLine() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportChirpsReport(t *testing.T) {
+	// The empty database inserts nothing, so every valid line is reported
+	// as already imported
+	cfg := &apiConfig{
+		db:     newEmptyQueries(),
+		dbConn: newFakeDB(nil).conn(),
+	}
+	archive := strings.Join([]string{
+		`{"external_id": "1", "body": "S'all good, man.", "created_at": "2009-04-26T21:00:00Z"}`,
+		``,
+		`not json`,
+		`{"external_id": "2", "body": "", "created_at": "2009-04-26T21:00:00Z"}`,
+		`{"external_id": "1", "body": "Better call Saul!", "created_at": "2009-04-26T21:00:00Z"}`,
+	}, "\n")
+
+	report, err := cfg.importChirps(t.Context(), uuid.New(), strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+
+	want := []chirpImportResult{
+		{Line: 1, ExternalID: "1", Status: importStatusDuplicate, Error: "Already imported"},
+		{Line: 3, Status: importStatusFailed},
+		{Line: 4, ExternalID: "2", Status: importStatusFailed, Error: "body is required"},
+		{Line: 5, ExternalID: "1", Status: importStatusDuplicate, Error: "Duplicate of line 1"},
+	}
+	if len(report.Lines) != len(want) {
+		t.Fatalf("got %d lines in report, want %d: %+v", len(report.Lines), len(want), report.Lines)
+	}
+	for i, w := range want {
+		got := report.Lines[i]
+		if got.Line != w.Line || got.ExternalID != w.ExternalID || got.Status != w.Status {
+			t.Errorf("line %d: got %+v, want %+v", i, got, w)
+		}
+		if w.Error != "" && got.Error != w.Error {
+			t.Errorf("line %d: got error %q, want %q", i, got.Error, w.Error)
+		}
+	}
+	if report.Imported != 0 || report.Duplicates != 2 || report.Failed != 2 {
+		t.Errorf("got counts %d/%d/%d, want 0/2/2", report.Imported, report.Duplicates, report.Failed)
+	}
+}
+
+func TestImportChirpsLineTooLong(t *testing.T) {
+	cfg := &apiConfig{
+		db:     newEmptyQueries(),
+		dbConn: newFakeDB(nil).conn(),
+	}
+	archive := "not json\n" + strings.Repeat("a", 3*importMaxLineBytes) + "\n" +
+		`{"external_id": "1", "body": "S'all good, man.", "created_at": "2009-04-26T21:00:00Z"}`
+
+	report, err := cfg.importChirps(t.Context(), uuid.New(), strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("expected an oversized line not to stop the import, got %v", err)
+	}
+	if len(report.Lines) != 3 || report.Failed != 2 || report.Duplicates != 1 {
+		t.Fatalf("expected the oversized line to fail on its own, got %+v", report)
+	}
+	if got := report.Lines[1]; got.Line != 2 || got.Status != importStatusFailed || !strings.Contains(got.Error, "longer than") {
+		t.Errorf("unexpected result for the oversized line %+v", got)
+	}
+	if report.Lines[2].Line != 3 {
+		t.Errorf("expected the line after it to be numbered 3, got %d", report.Lines[2].Line)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/stream"
+)
+
+const (
+	chirpEventChannel = "chirp_events"
+
+	chirpEventCreated = "chirp.created"
+	chirpEventDeleted = "chirp.deleted"
+
+	// chirpStreamReplaySize is how many recent events a reconnecting client
+	// can catch up on
+	chirpStreamReplaySize = 1000
+	// chirpStreamBufferSize is how far a client can fall behind before it is
+	// disconnected
+	chirpStreamBufferSize = 64
+	chirpStreamRetry      = 5 * time.Second
+	// chirpStreamHeartbeat keeps idle connections from being closed by proxies
+	chirpStreamHeartbeat = 15 * time.Second
+)
+
+// publishChirpEvent announces a chirp appearing or disappearing to stream
+// subscribers on every instance, and queues it for webhooks.  Run it in the
+// transaction that makes the change, so the event is sent if and only if the
+// change commits.
+func publishChirpEvent(ctx context.Context, q *database.Queries, eventType string, chirp database.Chirp) error {
+	data := Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserId:    chirp.UserID.UUID,
+	}
+	if err := queueWebhookEvent(ctx, q, eventType, chirp.UserID, data); err != nil {
+		return err
+	}
+
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return q.NotifyChirpEvent(ctx, database.NotifyChirpEventParams{
+		EventType: eventType,
+		Chirp:     dat,
+	})
+}
+
+// listenChirpEvents relays the chirp events sent by every instance, this one
+// included, into the local hub.
+func (cfg *apiConfig) listenChirpEvents(listener *pq.Listener) {
+	if err := listener.Listen(chirpEventChannel); err != nil {
+		slog.Error("Error listening for chirp events", "error", err)
+		return
+	}
+	for n := range listener.Notify {
+		if n == nil {
+			// Events sent while the connection was down are lost
+			slog.Warn("Reconnected to the chirp event channel")
+			continue
+		}
+		var msg chirpEventMessage
+		if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+			slog.Error("Error decoding chirp event", "error", err)
+			continue
+		}
+		cfg.chirpHub.Publish(stream.Event[Chirp]{
+			ID:   strconv.FormatInt(msg.ID, 10),
+			Type: msg.Type,
+			Data: msg.Chirp,
+		})
+	}
+}
+
+// listenerEventLogger logs the listener's connection problems
+func listenerEventLogger(event pq.ListenerEventType, err error) {
+	if err != nil {
+		slog.Warn("Chirp event listener connection problem", "event", event, "error", err)
+	}
+}
+
+// chirpStreamFilter decides which events a client is sent
+type chirpStreamFilter struct {
+	author  uuid.NullUUID
+	hashtag string
+	// mentioned is an email address the chirp must mention
+	mentioned string
+	// hidden are authors the viewer has blocked or muted, or who blocked them
+	hidden map[uuid.UUID]bool
+}
+
+func (f chirpStreamFilter) matches(chirp Chirp) bool {
+	if f.hidden[chirp.UserId] {
+		return false
+	}
+	if f.author.Valid && chirp.UserId != f.author.UUID {
+		return false
+	}
+	if f.hashtag != "" && !slices.Contains(hashtags(chirp.Body), f.hashtag) {
+		return false
+	}
+	if f.mentioned != "" && !slices.Contains(mentions(chirp.Body), f.mentioned) {
+		return false
+	}
+	return true
+}
+
+// hiddenAuthors is the set of users whose chirps the viewer shouldn't be sent
+func (cfg *apiConfig) hiddenAuthors(ctx context.Context, viewerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	ids, err := cfg.db.ListHiddenAuthors(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		hidden[id] = true
+	}
+	return hidden, nil
+}
+
+// hashtags returns the lower-cased tags in a chirp, without their #.  A tag
+// runs from the # to the first character that isn't a letter, digit or _.
+func hashtags(body string) []string {
+	var tags []string
+	for _, word := range strings.Fields(body) {
+		tag, ok := strings.CutPrefix(word, "#")
+		if !ok {
+			continue
+		}
+		if end := strings.IndexFunc(tag, notHashtagRune); end >= 0 {
+			tag = tag[:end]
+		}
+		if tag != "" {
+			tags = append(tags, strings.ToLower(tag))
+		}
+	}
+	return tags
+}
+
+func notHashtagRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
+
+// mentions returns the email addresses a chirp mentions, each once, in the
+// order they first appear.  Users have no handles, so a mention is an @
+// followed by the user's address, as in "@saul@bettercall.com".  Punctuation
+// ending a sentence isn't part of the address.  Addresses are matched as
+// written, the same as logins.
+func mentions(body string) []string {
+	var addresses []string
+	for _, word := range strings.Fields(body) {
+		address, ok := strings.CutPrefix(word, "@")
+		if !ok {
+			continue
+		}
+		address = strings.TrimRight(address, ".,;:!?)'\"")
+		local, domain, ok := strings.Cut(address, "@")
+		if !ok || local == "" || domain == "" || slices.Contains(addresses, address) {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// handlerChirpStream sends new and deleted chirps as Server-Sent Events.
+// Clients can narrow the stream with ?author_id= and ?hashtag=, and resume
+// after a dropped connection with Last-Event-ID.  A signed-in viewer isn't
+// sent chirps from users they've blocked or muted, as of when they connect.
+func (cfg *apiConfig) handlerChirpStream(wrt http.ResponseWriter, req *http.Request) {
+	viewer, err := cfg.viewer(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	filter := chirpStreamFilter{}
+	if s := req.URL.Query().Get("author_id"); s != "" {
+		authorID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", s))
+			return
+		}
+		filter.author = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+	if s := req.URL.Query().Get("hashtag"); s != "" {
+		tag := strings.TrimPrefix(s, "#")
+		if tag == "" || strings.IndexFunc(tag, notHashtagRune) >= 0 {
+			respondWithError(wrt, 400, "Invalid hashtag")
+			return
+		}
+		filter.hashtag = strings.ToLower(tag)
+	}
+	if viewer.Valid {
+		filter.hidden, err = cfg.hiddenAuthors(req.Context(), viewer.UUID)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error listing hidden authors", "user_id", viewer.UUID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+
+	sub, backlog, ok := cfg.chirpHub.Subscribe(req.Header.Get("Last-Event-ID"))
+	defer sub.Close()
+	chirpStreamSubscribers.Inc()
+	defer chirpStreamSubscribers.Dec()
+
+	rc := http.NewResponseController(wrt)
+	wrt.Header().Set("Content-Type", "text/event-stream")
+	wrt.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream
+	wrt.Header().Set("X-Accel-Buffering", "no")
+	wrt.WriteHeader(200)
+
+	fmt.Fprintf(wrt, "retry: %d\n\n", chirpStreamRetry.Milliseconds())
+	if !ok {
+		// The client missed events we no longer have, so it should reload
+		fmt.Fprint(wrt, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range backlog {
+		writeChirpEvent(wrt, event, filter)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(chirpStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, open := <-sub.Events():
+			if !open {
+				if !cfg.shuttingDown.Load() {
+					chirpStreamDropped.Inc()
+				}
+				return
+			}
+			if !writeChirpEvent(wrt, event, filter) {
+				continue
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(wrt, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeChirpEvent writes an event if it passes the filter, reporting whether
+// it did
+func writeChirpEvent(wrt http.ResponseWriter, event stream.Event[Chirp], filter chirpStreamFilter) bool {
+	if !filter.matches(event.Data) {
+		return false
+	}
+	dat, err := json.Marshal(event.Data)
+	if err != nil {
+		slog.Error("Error marshalling JSON", "error", err)
+		return false
+	}
+	fmt.Fprintf(wrt, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, dat)
+	return true
+}
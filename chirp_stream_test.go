@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/stream"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := map[string][]string{
+		"S'all good, man.":                  nil,
+		"#BetterCallSaul is on":             {"bettercallsaul"},
+		"Loving #go, #sql_c! and # alone":   {"go", "sql_c"},
+		"#café-culture in Albuquerque #ABQ": {"café", "abq"},
+	}
+	for body, want := range tests {
+		if got := hashtags(body); !reflect.DeepEqual(got, want) {
+			t.Errorf("hashtags(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := map[string][]string{
+		"S'all good, man.":                         nil,
+		"Better call @saul@bettercall.com!":        {"saul@bettercall.com"},
+		"@kim@wexlermcgill.com, @saul and @ alone": {"kim@wexlermcgill.com"},
+		"(cc @kim@hhm.com) @kim@hhm.com @x@":       {"kim@hhm.com"},
+	}
+	for body, want := range tests {
+		if got := mentions(body); !reflect.DeepEqual(got, want) {
+			t.Errorf("mentions(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func chirpEvent(id int, author uuid.UUID, body string) stream.Event[Chirp] {
+	return stream.Event[Chirp]{
+		ID:   strconv.Itoa(id),
+		Type: chirpEventCreated,
+		Data: Chirp{ID: uuid.New(), Body: body, UserId: author},
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	hub := stream.NewHub[Chirp](3, 10)
+	author := uuid.New()
+	for i := 1; i <= 5; i++ {
+		hub.Publish(chirpEvent(i, author, "chirp"))
+	}
+
+	_, backlog, ok := hub.Subscribe("3")
+	if !ok || len(backlog) != 2 || backlog[0].ID != "4" || backlog[1].ID != "5" {
+		t.Errorf("expected events 4 and 5 after 3, got %v (ok %v)", backlog, ok)
+	}
+	if _, _, ok := hub.Subscribe("1"); ok {
+		t.Error("expected an event that left the buffer to be reported missing")
+	}
+	if _, backlog, ok := hub.Subscribe(""); !ok || len(backlog) != 0 {
+		t.Errorf("expected a fresh subscription to start empty, got %v", backlog)
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := stream.NewHub[Chirp](10, 2)
+	slow, _, _ := hub.Subscribe("")
+	fast, _, _ := hub.Subscribe("")
+
+	author := uuid.New()
+	received := 0
+	for i := 1; i <= 3; i++ {
+		hub.Publish(chirpEvent(i, author, "chirp"))
+		<-fast.Events()
+		received++
+	}
+	if received != 3 || hub.Subscribers() != 1 {
+		t.Fatalf("expected only the slow subscriber to be dropped, %d subscribers left", hub.Subscribers())
+	}
+
+	count := 0
+	for range slow.Events() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected the slow subscriber to get its buffered events before the close, got %d", count)
+	}
+
+	hub.Close()
+	if _, open := <-fast.Events(); open {
+		t.Error("expected closing the hub to end subscriptions")
+	}
+}
+
+// readSSE collects events from a stream until it has n of them
+func readSSE(t *testing.T, r *bufio.Reader, n int) []map[string]string {
+	t.Helper()
+	var events []map[string]string
+	event := map[string]string{}
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if event["event"] != "" {
+				events = append(events, event)
+			}
+			event = map[string]string{}
+			continue
+		}
+		if field, value, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, ":") {
+			event[field] = value
+		}
+	}
+	return events
+}
+
+func TestHandlerChirpStream(t *testing.T) {
+	cfg := &apiConfig{
+		db:       newEmptyQueries(),
+		chirpHub: stream.NewHub[Chirp](10, 10),
+	}
+	server := httptest.NewServer(http.HandlerFunc(cfg.handlerChirpStream))
+	defer server.Close()
+
+	saul, kim := uuid.New(), uuid.New()
+	cfg.chirpHub.Publish(chirpEvent(1, saul, "Before the client connected #law"))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"?hashtag=LAW", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	// Wait until the handler has subscribed before publishing
+	for cfg.chirpHub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cfg.chirpHub.Publish(chirpEvent(2, kim, "No hashtag here"))
+	cfg.chirpHub.Publish(chirpEvent(3, kim, "Practicing #law"))
+
+	events := readSSE(t, bufio.NewReader(resp.Body), 1)
+	if events[0]["id"] != "3" || events[0]["event"] != chirpEventCreated || !strings.Contains(events[0]["data"], "Practicing #law") {
+		t.Errorf("unexpected event %v", events[0])
+	}
+}
+
+func TestHandlerChirpStreamReset(t *testing.T) {
+	cfg := &apiConfig{
+		db:       newEmptyQueries(),
+		chirpHub: stream.NewHub[Chirp](10, 10),
+	}
+	server := httptest.NewServer(http.HandlerFunc(cfg.handlerChirpStream))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "999")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer resp.Body.Close()
+
+	events := readSSE(t, bufio.NewReader(resp.Body), 1)
+	if events[0]["event"] != "reset" {
+		t.Errorf("expected a reset for an unknown Last-Event-ID, got %v", events[0])
+	}
+}
+
+func TestHandlerChirpStreamBadFilters(t *testing.T) {
+	cfg := &apiConfig{chirpHub: stream.NewHub[Chirp](10, 10)}
+	for _, query := range []string{"author_id=saul", "hashtag=two%20words", "hashtag=%23"} {
+		rec := httptest.NewRecorder()
+		cfg.handlerChirpStream(rec, httptest.NewRequest("GET", "/api/chirps/stream?"+query, nil))
+		if rec.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
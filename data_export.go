@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	// dataExportTTL is how long a finished archive can be downloaded
+	dataExportTTL     = 7 * 24 * time.Hour
+	dataExportTimeout = 5 * time.Minute
+)
+
+func dataExportResponse(job database.DataExport) DataExport {
+	resp := DataExport{
+		ID:        job.ID,
+		Status:    job.Status,
+		CreatedAt: job.CreatedAt,
+	}
+	if job.CompletedAt.Valid {
+		resp.CompletedAt = &job.CompletedAt.Time
+	}
+	if job.ExpiresAt.Valid {
+		resp.ExpiresAt = &job.ExpiresAt.Time
+	}
+	return resp
+}
+
+// handlerRequestDataExport queues an archive of the user's data.  Only one
+// export runs per user at a time; asking again returns the job in progress.
+func (cfg *apiConfig) handlerRequestDataExport(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	job, err := cfg.db.GetActiveDataExportForUser(req.Context(), userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		job, err = cfg.db.CreateDataExport(req.Context(), userId)
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating data export for user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Wake the worker without waiting for it
+	select {
+	case cfg.exportWake <- struct{}{}:
+	default:
+	}
+
+	wrt.Header().Set("Location", fmt.Sprintf("/api/users/export/%s", job.ID))
+	respondWithJSON(wrt, 202, dataExportResponse(job))
+}
+
+func (cfg *apiConfig) handlerGetDataExport(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("exportID")))
+		return
+	}
+	job, err := cfg.db.GetDataExport(req.Context(), database.GetDataExportParams{
+		ID:     exportID,
+		UserID: userId,
+	})
+	if err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No export found for id %v", exportID))
+		return
+	}
+
+	switch job.Status {
+	case "pending", "running":
+		respondWithJSON(wrt, 202, dataExportResponse(job))
+		return
+	case "failed":
+		respondWithJSON(wrt, 200, dataExportResponse(job))
+		return
+	}
+	if job.ExpiresAt.Valid && job.ExpiresAt.Time.Before(time.Now()) {
+		respondWithError(wrt, 410, "Export has expired, request a new one")
+		return
+	}
+
+	wrt.Header().Set("Content-Type", "application/zip")
+	wrt.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, job.CreatedAt.Format("2006-01-02")))
+	wrt.Header().Set("Cache-Control", "no-store")
+	wrt.WriteHeader(200)
+	wrt.Write(job.Archive)
+}
+
+// processDataExports builds queued archives one at a time, whenever a job is
+// queued and every interval to pick up jobs abandoned by another instance.
+func (cfg *apiConfig) processDataExports(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cfg.exportWake:
+		}
+		for {
+			job, err := cfg.db.ClaimDataExport(context.Background())
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				slog.Error("Error claiming data export", "error", err)
+				break
+			}
+			cfg.runDataExport(job)
+		}
+	}
+}
+
+func (cfg *apiConfig) runDataExport(job database.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportTimeout)
+	defer cancel()
+
+	buf := &bytes.Buffer{}
+	data, err := cfg.gatherExportData(ctx, job.UserID)
+	if err == nil {
+		err = writeExportArchive(buf, data)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error building data export", "export_id", job.ID, "error", err)
+		if err := cfg.db.FailDataExport(ctx, database.FailDataExportParams{
+			ID:    job.ID,
+			Error: sql.NullString{String: err.Error(), Valid: true},
+		}); err != nil {
+			slog.ErrorContext(ctx, "Error marking data export failed", "export_id", job.ID, "error", err)
+		}
+		return
+	}
+
+	if err := cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:        job.ID,
+		Archive:   buf.Bytes(),
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(dataExportTTL), Valid: true},
+	}); err != nil {
+		slog.ErrorContext(ctx, "Error saving data export", "export_id", job.ID, "error", err)
+	}
+}
+
+// gatherExportData collects everything Chirpy holds about a user.  Secrets
+// such as password hashes, token values and TOTP seeds are left out.
+func (cfg *apiConfig) gatherExportData(ctx context.Context, userID uuid.UUID) (exportData, error) {
+	user, err := cfg.db.GetUser(ctx, userID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error getting user: %w", err)
+	}
+	totp, err := cfg.db.GetUserTOTP(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return exportData{}, fmt.Errorf("error getting TOTP enrollment: %w", err)
+	}
+	data := exportData{
+		GeneratedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			ID:               user.ID,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+			Email:            user.Email,
+			EmailVerified:    user.EmailVerified,
+			Role:             user.Role,
+			TwoFactorEnabled: err == nil && totp.ConfirmedAt.Valid,
+		},
+	}
+
+	nullUserID := uuid.NullUUID{UUID: userID, Valid: true}
+	chirps, err := cfg.db.ListChirpsForUser(ctx, nullUserID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing chirps: %w", err)
+	}
+	data.Chirps = make([]exportChirp, len(chirps))
+	for i, chirp := range chirps {
+		data.Chirps[i] = exportChirp{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			Hidden:    chirp.HiddenAt.Valid,
+		}
+		if chirp.DeletedAt.Valid {
+			data.Chirps[i].DeletedAt = &chirp.DeletedAt.Time
+		}
+	}
+
+	sessions, err := cfg.db.ListRefreshTokensForUser(ctx, nullUserID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing sessions: %w", err)
+	}
+	data.Sessions = make([]exportSession, len(sessions))
+	for i, session := range sessions {
+		data.Sessions[i] = exportSession{
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+		}
+		if session.RevokedAt.Valid {
+			data.Sessions[i].RevokedAt = &session.RevokedAt.Time
+		}
+	}
+
+	apiKeys, err := cfg.db.ListAPIKeysForUser(ctx, userID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing API keys: %w", err)
+	}
+	data.APIKeys = make([]APIKey, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		data.APIKeys[i] = apiKeyResponse(apiKey)
+	}
+
+	identities, err := cfg.db.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing identities: %w", err)
+	}
+	data.Identities = make([]LinkedIdentity, len(identities))
+	for i, identity := range identities {
+		data.Identities[i] = LinkedIdentity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		}
+	}
+
+	blocks, err := cfg.db.ListBlockedUsers(ctx, userID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing blocks: %w", err)
+	}
+	data.Blocks = make([]Relationship, len(blocks))
+	for i, block := range blocks {
+		data.Blocks[i] = Relationship{UserID: block.BlockedID, CreatedAt: block.CreatedAt}
+	}
+
+	mutes, err := cfg.db.ListMutedUsers(ctx, userID)
+	if err != nil {
+		return exportData{}, fmt.Errorf("error listing mutes: %w", err)
+	}
+	data.Mutes = make([]Relationship, len(mutes))
+	for i, mute := range mutes {
+		data.Mutes[i] = Relationship{UserID: mute.MutedID, CreatedAt: mute.CreatedAt}
+	}
+
+	return data, nil
+}
+
+var exportChirpsTemplate = template.Must(template.New("chirps").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Chirps by {{.Profile.Email}}</title></head>
+<body>
+<h1>Chirps by {{.Profile.Email}}</h1>
+<p>Exported {{.GeneratedAt.Format "2 January 2006 15:04 MST"}}</p>
+{{range .Chirps}}<article>
+<p>{{.Body}}</p>
+<p><small>{{.CreatedAt.Format "2 January 2006 15:04"}}{{if .Hidden}} &middot; hidden by moderators{{end}}{{if .DeletedAt}} &middot; deleted{{end}}</small></p>
+</article>
+{{else}}<p>No chirps.</p>
+{{end}}</body>
+</html>
+`))
+
+const exportReadme = `This archive holds everything Chirpy stores about your account.
+
+profile.json     your account details
+chirps.json      every chirp you have posted, including deleted ones not yet purged
+chirps.html      the same chirps, readable in a browser
+sessions.json    when you signed in, and when each session expires
+api_keys.json    your API keys (the keys themselves are never stored)
+identities.json  accounts at other identity providers linked to yours
+blocks.json      users you have blocked
+mutes.json       users you have muted
+
+Passwords, TOTP secrets and tokens are stored only in hashed or encrypted
+form, or not at all, and are not included.
+`
+
+// writeExportArchive writes data to w as a ZIP archive of JSON files plus a
+// human-readable copy of the chirps.
+func writeExportArchive(w io.Writer, data exportData) error {
+	zw := zip.NewWriter(w)
+	add := func(name string, write func(io.Writer) error) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: data.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		return write(f)
+	}
+	addJSON := func(name string, v any) error {
+		return add(name, func(f io.Writer) error {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		})
+	}
+
+	if err := add("README.txt", func(f io.Writer) error {
+		_, err := io.WriteString(f, exportReadme)
+		return err
+	}); err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", data.Profile},
+		{"chirps.json", data.Chirps},
+		{"sessions.json", data.Sessions},
+		{"api_keys.json", data.APIKeys},
+		{"identities.json", data.Identities},
+		{"blocks.json", data.Blocks},
+		{"mutes.json", data.Mutes},
+	}
+	for _, file := range files {
+		if err := addJSON(file.name, file.v); err != nil {
+			return fmt.Errorf("error writing %s: %w", file.name, err)
+		}
+	}
+	if err := add("chirps.html", func(f io.Writer) error {
+		return exportChirpsTemplate.Execute(f, data)
+	}); err != nil {
+		return fmt.Errorf("error writing chirps.html: %w", err)
+	}
+	return zw.Close()
+}
+
+// pruneDataExports periodically deletes expired archives and old failed jobs.
+func (cfg *apiConfig) pruneDataExports(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cfg.db.DeleteExpiredDataExports(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error deleting expired data exports", "error", err)
+		}
+		cancel()
+	}
+}
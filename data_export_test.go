@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWriteExportArchive(t *testing.T) {
+	data := exportData{
+		GeneratedAt: time.Now().UTC(),
+		Profile:     exportProfile{ID: uuid.New(), Email: "saul@bettercall.com"},
+		Chirps: []exportChirp{
+			{ID: uuid.New(), Body: "S'all good, man."},
+			{ID: uuid.New(), Body: "<script>alert(1)</script>"},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeExportArchive(buf, data); err != nil {
+		t.Fatalf("error writing archive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("error reading archive: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("error opening %s: %v", f.Name, err)
+		}
+		contents, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(contents)
+	}
+	for _, name := range []string{"README.txt", "profile.json", "chirps.json", "chirps.html", "sessions.json", "api_keys.json", "identities.json", "blocks.json", "mutes.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive is missing %s", name)
+		}
+	}
+
+	var chirps []exportChirp
+	if err := json.Unmarshal([]byte(files["chirps.json"]), &chirps); err != nil || len(chirps) != 2 {
+		t.Errorf("expected 2 chirps in chirps.json, got %v (err %v)", chirps, err)
+	}
+	if !strings.Contains(files["chirps.html"], "S&#39;all good, man.") {
+		t.Errorf("chirps.html is missing a chirp: %s", files["chirps.html"])
+	}
+	if strings.Contains(files["chirps.html"], "<script>") {
+		t.Errorf("chirps.html does not escape chirp bodies: %s", files["chirps.html"])
+	}
+}
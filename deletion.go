@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	defaultDeletionGracePeriod = 30 * 24 * time.Hour
+	purgeBatchSize             = 500
+	// purgeBatchPause gives other writers a turn between batches
+	purgeBatchPause = 100 * time.Millisecond
+)
+
+// loadDeletionGracePeriod reads DELETION_GRACE_PERIOD, how long deleted chirps
+// and accounts can be restored before they're purged (e.g. "720h").
+func loadDeletionGracePeriod() (time.Duration, error) {
+	period := os.Getenv("DELETION_GRACE_PERIOD")
+	if period == "" {
+		return defaultDeletionGracePeriod, nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid DELETION_GRACE_PERIOD %q", period)
+	}
+	return d, nil
+}
+
+// restorable reports whether something deleted at deletedAt is still within
+// the grace period.
+func (cfg *apiConfig) restorable(deletedAt sql.NullTime) bool {
+	return deletedAt.Valid && time.Since(deletedAt.Time) < cfg.deletionGracePeriod
+}
+
+// softDeleteUser marks a user and their chirps deleted, signs out every
+// session and revokes their API keys and OAuth grants.  Restoring the account
+// doesn't bring those back.  It should run in a transaction.
+func softDeleteUser(ctx context.Context, q *database.Queries, userID uuid.UUID) (database.User, error) {
+	user, err := q.SoftDeleteUser(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+	if err := q.SoftDeleteChirpsForUser(ctx, database.SoftDeleteChirpsForUserParams{
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		DeletedAt: user.DeletedAt,
+	}); err != nil {
+		return database.User{}, err
+	}
+	if err := q.RevokeAllRefreshTokensForUser(ctx, uuid.NullUUID{UUID: userID, Valid: true}); err != nil {
+		return database.User{}, err
+	}
+	if err := q.RevokeAllAPIKeysForUser(ctx, userID); err != nil {
+		return database.User{}, err
+	}
+	if err := q.RevokeAllOAuthRefreshTokensForUser(ctx, userID); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// restoreUser undoes softDeleteUser, bringing back only the chirps that were
+// deleted with the account.  It should run in a transaction.
+func restoreUser(ctx context.Context, q *database.Queries, user database.User) (database.User, error) {
+	if err := q.RestoreChirpsForUser(ctx, database.RestoreChirpsForUserParams{
+		UserID:    uuid.NullUUID{UUID: user.ID, Valid: true},
+		DeletedAt: user.DeletedAt,
+	}); err != nil {
+		return database.User{}, err
+	}
+	return q.RestoreUser(ctx, user.ID)
+}
+
+func (cfg *apiConfig) handlerRestoreChirp(wrt http.ResponseWriter, req *http.Request) {
+	userId := principalFromContext(req.Context()).UserID
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("chirpID")))
+		return
+	}
+	chirp, err := cfg.db.GetDeletedChirp(req.Context(), chirpID)
+	if err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No deleted chirp found for id %v", chirpID))
+		return
+	}
+	if chirp.UserID.UUID != userId {
+		wrt.WriteHeader(403)
+		return
+	}
+	// Chirps deleted along with the account come back with it, not one by one
+	if _, err := cfg.db.GetUser(req.Context(), userId); err != nil {
+		wrt.WriteHeader(401)
+		return
+	}
+	if !cfg.restorable(chirp.DeletedAt) {
+		respondWithError(wrt, 410, "Chirp can no longer be restored")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+	chirp, err = qtx.RestoreChirp(req.Context(), chirpID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error restoring chirp", "chirp_id", chirpID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !chirp.HiddenAt.Valid {
+		if err := publishChirpEvent(req.Context(), qtx, chirpEventCreated, chirp); err != nil {
+			slog.ErrorContext(req.Context(), "Error publishing chirp event", "type", chirpEventCreated, "chirp_id", chirp.ID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing chirp restore", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserId:    chirp.UserID.UUID,
+	})
+}
+
+func (cfg *apiConfig) handlerDeleteUser(wrt http.ResponseWriter, req *http.Request) {
+	admin, ok := cfg.authorize(wrt, req, roleAdmin)
+	if !ok {
+		return
+	}
+
+	userId, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("userID")))
+		return
+	}
+	if userId == admin.ID {
+		respondWithError(wrt, 400, "You cannot delete yourself")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := softDeleteUser(req.Context(), cfg.withTx(tx), userId); errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 404, fmt.Sprintf("No user found for id %v", userId))
+		return
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing user deletion", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerRestoreUser(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleAdmin); !ok {
+		return
+	}
+
+	userId, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("userID")))
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	user, err := qtx.GetDeletedUser(req.Context(), userId)
+	if err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No deleted user found for id %v", userId))
+		return
+	}
+	if !cfg.restorable(user.DeletedAt) {
+		respondWithError(wrt, 410, "User can no longer be restored")
+		return
+	}
+	if _, err := restoreUser(req.Context(), qtx, user); err != nil {
+		slog.ErrorContext(req.Context(), "Error restoring user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing user restore", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// purgeDeleted periodically and permanently removes chirps and users deleted
+// longer ago than the grace period.  Rows go in small batches so no statement
+// holds locks for long; chirps go first so purging a user cascades cheaply.
+func (cfg *apiConfig) purgeDeleted(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-cfg.deletionGracePeriod)
+		chirps, err := purgeInBatches(func(ctx context.Context) (int64, error) {
+			return cfg.db.PurgeDeletedChirps(ctx, database.PurgeDeletedChirpsParams{
+				Cutoff:    cutoff,
+				BatchSize: purgeBatchSize,
+			})
+		})
+		if err != nil {
+			slog.Error("Error purging deleted chirps", "error", err)
+			continue
+		}
+		users, err := purgeInBatches(func(ctx context.Context) (int64, error) {
+			return cfg.db.PurgeDeletedUsers(ctx, database.PurgeDeletedUsersParams{
+				Cutoff:    cutoff,
+				BatchSize: purgeBatchSize,
+			})
+		})
+		if err != nil {
+			slog.Error("Error purging deleted users", "error", err)
+			continue
+		}
+		if chirps > 0 || users > 0 {
+			slog.Info("Purged deleted chirps and users", "chirps", chirps, "users", users)
+		}
+	}
+}
+
+// purgeInBatches calls purge until it removes less than a full batch, and
+// returns the total removed.
+func purgeInBatches(purge func(ctx context.Context) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		n, err := purge(ctx)
+		cancel()
+		total += n
+		if err != nil || n < purgeBatchSize {
+			return total, err
+		}
+		time.Sleep(purgeBatchPause)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadDeletionGracePeriod(t *testing.T) {
+	t.Setenv("DELETION_GRACE_PERIOD", "")
+	if d, err := loadDeletionGracePeriod(); err != nil || d != defaultDeletionGracePeriod {
+		t.Errorf("expected default grace period %v, got %v (err %v)", defaultDeletionGracePeriod, d, err)
+	}
+
+	t.Setenv("DELETION_GRACE_PERIOD", "48h")
+	if d, err := loadDeletionGracePeriod(); err != nil || d != 48*time.Hour {
+		t.Errorf("expected 48h, got %v (err %v)", d, err)
+	}
+
+	for _, invalid := range []string{"0s", "-1h", "a week"} {
+		t.Setenv("DELETION_GRACE_PERIOD", invalid)
+		if _, err := loadDeletionGracePeriod(); err == nil {
+			t.Errorf("expected error for DELETION_GRACE_PERIOD=%q", invalid)
+		}
+	}
+}
+
+func TestRestorable(t *testing.T) {
+	cfg := &apiConfig{deletionGracePeriod: time.Hour}
+	if !cfg.restorable(sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}) {
+		t.Errorf("expected recent deletion to be restorable")
+	}
+	if cfg.restorable(sql.NullTime{Time: time.Now().Add(-2 * time.Hour), Valid: true}) {
+		t.Errorf("expected deletion past the grace period not to be restorable")
+	}
+	if cfg.restorable(sql.NullTime{}) {
+		t.Errorf("expected rows that aren't deleted not to be restorable")
+	}
+}
+
+func TestPurgeInBatches(t *testing.T) {
+	batches := []int64{purgeBatchSize, purgeBatchSize, 7}
+	calls := 0
+	total, err := purgeInBatches(func(context.Context) (int64, error) {
+		n := batches[calls]
+		calls++
+		return n, nil
+	})
+	if err != nil || total != 2*purgeBatchSize+7 || calls != 3 {
+		t.Errorf("expected 3 batches totalling %d, got %d calls totalling %d (err %v)", 2*purgeBatchSize+7, calls, total, err)
+	}
+
+	calls = 0
+	_, err = purgeInBatches(func(context.Context) (int64, error) {
+		calls++
+		return 0, errors.New("deadlock detected")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected purge to stop at the first error, got %d calls (err %v)", calls, err)
+	}
+}
+
+func TestReadsExcludeDeleted(t *testing.T) {
+	db := newFakeDB(nil)
+	q := db.queries()
+	ctx := context.Background()
+	q.GetAllChirps(ctx)
+	q.GetChirpsForViewer(ctx, uuid.New())
+	q.GetChirp(ctx, uuid.New())
+	q.GetUser(ctx, uuid.New())
+	q.GetUserByEmail(ctx, "saul@bettercall.com")
+
+	for _, name := range []string{"GetAllChirps", "GetChirpsForViewer", "GetChirp", "GetUser", "GetUserByEmail"} {
+		if !strings.Contains(db.statement(name), "deleted_at IS NULL") {
+			t.Errorf("expected %s to leave out deleted rows:\n%s", name, db.statement(name))
+		}
+	}
+}
+
+func TestHandlerRestoreChirp(t *testing.T) {
+	userID := uuid.New()
+	chirpID := uuid.New()
+	for _, c := range []struct {
+		name      string
+		deletedAt time.Time
+		want      int
+	}{
+		{"within grace period", time.Now().Add(-time.Hour), 200},
+		{"after grace period", time.Now().Add(-48 * time.Hour), 410},
+	} {
+		deleted := chirpRow(chirpID, userID, nil)
+		deleted[6] = c.deletedAt
+		db := newFakeDB(map[string][]driver.Value{
+			"GetDeletedChirp": deleted,
+			"GetUser":         userRow(userID, "saul@bettercall.com", true, roleUser),
+			"RestoreChirp":    chirpRow(chirpID, userID, nil),
+		})
+		cfg := db.apiConfig()
+		cfg.deletionGracePeriod = 24 * time.Hour
+
+		req := requestAs(t, cfg, userID, "POST", "/api/chirps/"+chirpID.String()+"/restore", "")
+		rec := serve("POST /api/chirps/{chirpID}/restore", cfg.middlewareAuth(scopeChirpsWrite, cfg.handlerRestoreChirp), req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, rec.Code)
+		}
+		if restored := db.called("RestoreChirp"); restored != (c.want == 200) {
+			t.Errorf("%s: expected restored %v, got %v", c.name, c.want == 200, restored)
+		}
+		if queued := db.called("QueueWebhookDeliveries"); queued != (c.want == 200) {
+			t.Errorf("%s: expected the event queued %v, got %v", c.name, c.want == 200, queued)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nfongster/chirpy/internal/mailer"
+)
+
+func TestValidateEmail(t *testing.T) {
+	valid := []string{"saul@bettercall.com", "kim.wexler+hhm@law.example.org"}
+	for _, email := range valid {
+		if !validateEmail(email) {
+			t.Errorf("Expected \"%s\" to be a valid email", email)
+		}
+	}
+}
+
+func TestValidateEmailInvalid(t *testing.T) {
+	invalid := []string{"", "saul", "saul@", "@bettercall.com", "Saul <saul@bettercall.com>", "saul@bettercall.com\nBcc: x@y.z"}
+	for _, email := range invalid {
+		if validateEmail(email) {
+			t.Errorf("Expected \"%s\" to be an invalid email", email)
+		}
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := mailer.NewLogMailer(buf, "no-reply@chirpy.local")
+
+	err := m.Send(context.Background(), mailer.Message{
+		To:      "saul@bettercall.com",
+		Subject: "Verify your Chirpy account\r\nBcc: evil@example.com",
+		Body:    "token: abc123",
+	})
+	if err != nil {
+		t.Errorf("error sending message: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "To: saul@bettercall.com\r\n") || !strings.Contains(out, "token: abc123") {
+		t.Errorf("message was not written to log: %q", out)
+	}
+	if strings.Contains(out, "\r\nBcc:") {
+		t.Errorf("header injection was not stripped: %q", out)
+	}
+}
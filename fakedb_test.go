@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// fakeDB is the database/sql driver tests run handlers against.  It answers
+// each query with the row given for its sqlc name, or for its full text if
+// it isn't an sqlc query, and with no rows for queries it hasn't been given.
+// It records which queries ran.  Statements named in rows report one row
+// affected, and transactions always commit.
+type fakeDB struct {
+	mu         sync.Mutex
+	rows       map[string][]driver.Value
+	calls      []string
+	statements map[string]string
+}
+
+func newFakeDB(rows map[string][]driver.Value) *fakeDB {
+	return &fakeDB{rows: rows, statements: map[string]string{}}
+}
+
+func (db *fakeDB) conn() *sql.DB {
+	return sql.OpenDB(db)
+}
+
+func (db *fakeDB) queries() *database.Queries {
+	return database.New(db.conn())
+}
+
+// apiConfig returns a config whose queries and transactions go to db
+func (db *fakeDB) apiConfig() *apiConfig {
+	conn := db.conn()
+	return &apiConfig{secret: "secret", db: database.New(conn), dbConn: conn}
+}
+
+// called reports whether the named query ran
+func (db *fakeDB) called(name string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return slices.Contains(db.calls, name)
+}
+
+// statement returns the SQL the named query last ran
+func (db *fakeDB) statement(name string) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.statements[name]
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return fakeConn{db}, nil }
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	name := query
+	if sqlcQuery, ok := strings.CutPrefix(query, "-- name: "); ok {
+		name, _, _ = strings.Cut(sqlcQuery, " ")
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.statements[name] = query
+	return fakeStmt{db: c.db, name: name}, nil
+}
+func (fakeConn) Close() error              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db   *fakeDB
+	name string
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) record() ([]driver.Value, bool) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.calls = append(s.db.calls, s.name)
+	row, ok := s.db.rows[s.name]
+	return row, ok
+}
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if _, ok := s.record(); ok {
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	row, _ := s.record()
+	return &fakeRows{row: row, done: row == nil}, nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return make([]string, len(r.row)) }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+// newEmptyQueries returns queries on which every lookup finds nothing, for
+// tests of handlers that only look things up.
+func newEmptyQueries() *database.Queries {
+	return newFakeDB(nil).queries()
+}
+
+// newActiveUserQueries is newEmptyQueries, except that whichever user is
+// asked for exists, for handlers that check their caller's account.
+func newActiveUserQueries() *database.Queries {
+	return newFakeDB(map[string][]driver.Value{
+		"GetUser": userRow(uuid.New(), "saul@bettercall.com", true, roleUser),
+	}).queries()
+}
+
+// userRow is a users row, as returned by GetUser and friends
+func userRow(id uuid.UUID, email string, emailVerified bool, role string) []driver.Value {
+	return []driver.Value{id.String(), time.Now(), time.Now(), email, "unset", emailVerified, role, nil}
+}
+
+// chirpRow is a chirps row, as returned by GetChirp
+func chirpRow(id, authorID uuid.UUID, hiddenAt any) []driver.Value {
+	return []driver.Value{id.String(), time.Now(), time.Now(), "Hello", authorID.String(), hiddenAt, nil, nil}
+}
+
+// requestAs builds a request carrying an access token for userID
+func requestAs(t *testing.T, cfg *apiConfig, userID uuid.UUID, method, target, body string) *http.Request {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, cfg.secret, time.Minute)
+	if err != nil {
+		t.Fatalf("error making JWT: %v", err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// serve routes req to handler registered under pattern, so that the
+// handler sees the pattern's path values, and records the response.
+func serve(pattern string, handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
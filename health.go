@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// readinessTimeout bounds all of the readiness checks together
+	readinessTimeout = 2 * time.Second
+
+	defaultShutdownDrainDelay = 5 * time.Second
+	shutdownTimeout           = 30 * time.Second
+)
+
+//go:embed sql/schema/*.sql
+var schemaFiles embed.FS
+
+// schemaVersion is the newest goose migration this build ships with
+var schemaVersion = latestSchemaVersion()
+
+func latestSchemaVersion() int64 {
+	entries, err := schemaFiles.ReadDir("sql/schema")
+	if err != nil {
+		panic(err)
+	}
+	var latest int64
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("migration %s has no version number", entry.Name()))
+		}
+		latest = max(latest, version)
+	}
+	return latest
+}
+
+// loadShutdownDrainDelay reads SHUTDOWN_DRAIN_DELAY, how long the server keeps
+// serving after it starts reporting not-ready, so load balancers have time to
+// notice before connections are closed (e.g. "10s").
+func loadShutdownDrainDelay() (time.Duration, error) {
+	delay := os.Getenv("SHUTDOWN_DRAIN_DELAY")
+	if delay == "" {
+		return defaultShutdownDrainDelay, nil
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_DRAIN_DELAY %q", delay)
+	}
+	return d, nil
+}
+
+// handlerLiveness reports that the process is up.  It checks nothing else,
+// so a database outage doesn't get healthy instances restarted.
+func handlerLiveness(wrt http.ResponseWriter, _ *http.Request) {
+	wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	wrt.WriteHeader(200)
+	wrt.Write([]byte("OK\n"))
+}
+
+// handlerReadiness reports whether this instance should be sent traffic: the
+// database answers, its schema is at least as new as this build expects, and
+// the server isn't shutting down.
+func (cfg *apiConfig) handlerReadiness(wrt http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+	defer cancel()
+
+	report := readinessReport{
+		Status: "ready",
+		Checks: map[string]readinessCheck{},
+	}
+	check := func(name string, err error) {
+		if err != nil {
+			report.Status = "not ready"
+			report.Checks[name] = readinessCheck{Status: "fail", Error: err.Error()}
+			return
+		}
+		report.Checks[name] = readinessCheck{Status: "ok"}
+	}
+
+	if cfg.shuttingDown.Load() {
+		check("shutdown", errors.New("server is shutting down"))
+	} else {
+		check("shutdown", nil)
+	}
+
+	// Details of connection errors are logged rather than shown to callers
+	if err := cfg.dbConn.PingContext(ctx); err != nil {
+		slog.WarnContext(ctx, "Readiness check could not reach the database", "error", err)
+		check("database", errors.New("database is unreachable"))
+		check("migrations", errors.New("database is unreachable"))
+	} else {
+		check("database", nil)
+		check("migrations", checkSchemaVersion(ctx, cfg.dbConn))
+	}
+
+	code := 200
+	if report.Status != "ready" {
+		code = 503
+	}
+	wrt.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(wrt, code, report)
+}
+
+// schemaVersionQuery reads the latest migration goose applied.  goose's
+// table isn't part of the schema sqlc knows about, so it is queried directly.
+const schemaVersionQuery = `SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1`
+
+// checkSchemaVersion compares goose's record of applied migrations with the
+// migrations this build ships with.
+func checkSchemaVersion(ctx context.Context, db *sql.DB) error {
+	var version int64
+	err := db.QueryRowContext(ctx, schemaVersionQuery).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no migrations have been applied")
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Readiness check could not read the migration version", "error", err)
+		return errors.New("could not read the migration version")
+	}
+	if version < schemaVersion {
+		return fmt.Errorf("database is at migration %d, want %d", version, schemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	// Migrations are numbered from 1 with no gaps
+	entries, err := schemaFiles.ReadDir("sql/schema")
+	if err != nil {
+		t.Fatalf("error reading migrations: %v", err)
+	}
+	if schemaVersion != int64(len(entries)) {
+		t.Errorf("expected schema version %d, got %d", len(entries), schemaVersion)
+	}
+}
+
+func TestHandlerReadiness(t *testing.T) {
+	tests := map[string]struct {
+		version      []driver.Value
+		shuttingDown bool
+		wantCode     int
+		wantFailed   []string
+	}{
+		"ready": {
+			version:  []driver.Value{schemaVersion},
+			wantCode: 200,
+		},
+		"old schema": {
+			version:    []driver.Value{schemaVersion - 1},
+			wantCode:   503,
+			wantFailed: []string{"migrations"},
+		},
+		"no migrations": {
+			wantCode:   503,
+			wantFailed: []string{"migrations"},
+		},
+		"shutting down": {
+			version:      []driver.Value{schemaVersion},
+			shuttingDown: true,
+			wantCode:     503,
+			wantFailed:   []string{"shutdown"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := newFakeDB(map[string][]driver.Value{schemaVersionQuery: tc.version})
+			cfg := &apiConfig{dbConn: db.conn()}
+			cfg.shuttingDown.Store(tc.shuttingDown)
+
+			rec := httptest.NewRecorder()
+			cfg.handlerReadiness(rec, httptest.NewRequest("GET", "/api/readyz", nil))
+			if rec.Code != tc.wantCode {
+				t.Errorf("expected %d, got %d", tc.wantCode, rec.Code)
+			}
+
+			var report readinessReport
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatalf("error decoding report: %v", err)
+			}
+			failed := map[string]bool{}
+			for _, name := range tc.wantFailed {
+				failed[name] = true
+			}
+			for _, name := range []string{"database", "migrations", "shutdown"} {
+				check, ok := report.Checks[name]
+				if !ok {
+					t.Errorf("expected a %s check", name)
+					continue
+				}
+				if want := map[bool]string{true: "fail", false: "ok"}[failed[name]]; check.Status != want {
+					t.Errorf("expected %s check to be %s, got %+v", name, want, check)
+				}
+			}
+		})
+	}
+}
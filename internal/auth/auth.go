@@ -2,7 +2,9 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,19 +12,15 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(bytes), nil
+	return DefaultHashers.Hash(password)
 }
 
 func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	_, err := DefaultHashers.Verify(password, hash)
+	return err
 }
 
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
@@ -42,10 +40,14 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	claims := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return uuid.UUID{}, err
 	}
+	// Access tokens have no audience; anything with one was minted for another purpose
+	if len(claims.Audience) > 0 {
+		return uuid.UUID{}, fmt.Errorf("token is not an access token")
+	}
 	subjStr, err := token.Claims.GetSubject()
 	if err != nil {
 		return uuid.UUID{}, err
@@ -53,11 +55,63 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return uuid.Parse(subjStr)
 }
 
+// TokenExpiry returns when a token that has already been validated expires.
+func TokenExpiry(tokenString string) (time.Time, error) {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, fmt.Errorf("token has no expiry")
+	}
+	return claims.ExpiresAt.Time, nil
+}
+
+const mfaAudience = "chirpy-mfa"
+
+// MakeMFAChallengeToken returns a short-lived token proving the user has passed
+// the password step of login.  It can't be used as an access token.
+func MakeMFAChallengeToken(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	curTime := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{mfaAudience},
+		IssuedAt:  jwt.NewNumericDate(curTime),
+		ExpiresAt: jwt.NewNumericDate(curTime.Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func ValidateMFAChallengeToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(mfaAudience))
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return uuid.Parse(claims.Subject)
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
+	return getAuthorization(headers, "Bearer")
+}
+
+// GetAPIKey returns the key from an "Authorization: ApiKey <key>" header.
+func GetAPIKey(headers http.Header) (string, error) {
+	return getAuthorization(headers, "ApiKey")
+}
+
+func getAuthorization(headers http.Header, scheme string) (string, error) {
 	authHeader := headers.Get("Authorization")
 	splitAuthHeader := strings.Split(authHeader, " ")
 	if len(splitAuthHeader) < 2 {
-		return "", fmt.Errorf("auth header was invalid: %s", authHeader)
+		// The header isn't included, as it may well hold a credential
+		return "", errors.New("auth header was missing or invalid")
+	}
+	if !strings.EqualFold(splitAuthHeader[0], scheme) {
+		return "", fmt.Errorf("auth header did not use the %s scheme", scheme)
 	}
 
 	return splitAuthHeader[1], nil
@@ -71,3 +125,25 @@ func MakeRefreshToken() (string, error) {
 	}
 	return hex.EncodeToString(key), nil
 }
+
+// HashToken returns the hex-encoded SHA-256 digest of a random token, so tokens
+// can be stored and looked up without keeping the plaintext in the database.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MakeAPIKey returns a new key of the form chirpy_<prefix>_<secret>.  The
+// prefix is stored in the clear so users can tell their keys apart.
+func MakeAPIKey() (key, prefix string, err error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	secret, err := MakeRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	return "chirpy_" + prefix + "_" + secret, prefix, nil
+}
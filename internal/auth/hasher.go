@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match hash")
+	ErrUnknownHash      = errors.New("hash was not produced by a known algorithm")
+)
+
+// PasswordHasher hashes passwords with one algorithm.  Hashes are self-describing
+// (PHC or modular crypt format), so the algorithm and parameters travel with them.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) error
+	// Identifies reports whether hash was produced by this hasher's algorithm
+	Identifies(hash string) bool
+	// Outdated reports whether hash was produced with different parameters than the hasher currently uses
+	Outdated(hash string) bool
+}
+
+// Argon2idHasher produces hashes of the form
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher uses the OWASP-recommended minimum parameters (19 MiB, 2 passes, 1 lane).
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, hash string) error {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Outdated(hash string) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory ||
+		params.iterations != h.Iterations ||
+		params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.SaltLength ||
+		uint32(len(params.key)) != h.KeyLength
+}
+
+func parseArgon2id(hash string) (argon2idParams, error) {
+	params := argon2idParams{}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	// argon2 panics on these rather than returning an error
+	if params.iterations == 0 || params.parallelism == 0 {
+		return params, fmt.Errorf("invalid argon2 parameters: %s", parts[3])
+	}
+
+	var err error
+	b64 := base64.RawStdEncoding
+	if params.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return params, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	if params.key, err = b64.DecodeString(parts[5]); err != nil {
+		return params, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+	if len(params.key) == 0 {
+		return params, errors.New("invalid argon2 key: empty")
+	}
+	return params, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		Cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+func (h *BcryptHasher) Verify(password, hash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Hashers hashes new passwords with Current and verifies existing hashes with
+// whichever hasher produced them, so stored hashes can be upgraded over time.
+type Hashers struct {
+	Current PasswordHasher
+	Legacy  []PasswordHasher
+}
+
+func NewHashers(current PasswordHasher, legacy ...PasswordHasher) *Hashers {
+	return &Hashers{
+		Current: current,
+		Legacy:  legacy,
+	}
+}
+
+func (h *Hashers) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+// Verify checks password against hash.  When it matches, needsRehash reports
+// whether the hash should be replaced with one from h.Hash.
+func (h *Hashers) Verify(password, hash string) (needsRehash bool, err error) {
+	if h.Current.Identifies(hash) {
+		if err := h.Current.Verify(password, hash); err != nil {
+			return false, err
+		}
+		return h.Current.Outdated(hash), nil
+	}
+	for _, hasher := range h.Legacy {
+		if hasher.Identifies(hash) {
+			if err := hasher.Verify(password, hash); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, ErrUnknownHash
+}
+
+// DefaultHashers hashes with argon2id and still accepts bcrypt hashes made before the switch.
+var DefaultHashers = NewHashers(NewArgon2idHasher(), NewBcryptHasher(bcrypt.DefaultCost))
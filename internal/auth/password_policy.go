@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PolicyViolation describes one way in which a password fails a PasswordPolicy.
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowEmail rejects passwords containing the account's email address or its local part
+	DisallowEmail bool
+	// Blocklist holds lower-cased passwords known to be common or breached
+	Blocklist map[string]struct{}
+}
+
+// DefaultPasswordPolicy returns the policy used when nothing is configured,
+// including the bundled list of common passwords.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	blocklist, _ := LoadPasswordBlocklist(strings.NewReader(commonPasswords))
+	return &PasswordPolicy{
+		MinLength: 8,
+		// Bounds the hashing work one request can ask for, and is the most
+		// bcrypt accepts, so passwords stay hashable if PASSWORD_HASH is
+		// switched to it
+		MaxLength:     72,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		DisallowEmail: true,
+		Blocklist:     blocklist,
+	}
+}
+
+// LoadPasswordBlocklist reads one password per line, skipping blank lines and
+// lines starting with '#'.
+func LoadPasswordBlocklist(r io.Reader) (map[string]struct{}, error) {
+	blocklist := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// Check returns every rule the password breaks, or nil if it satisfies the policy.
+func (p *PasswordPolicy) Check(password, email string) []PolicyViolation {
+	violations := []PolicyViolation{}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PolicyViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PolicyViolation{
+			Code:    "missing_lower",
+			Message: "Password must contain a lowercase letter",
+		})
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PolicyViolation{
+			Code:    "missing_upper",
+			Message: "Password must contain an uppercase letter",
+		})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PolicyViolation{
+			Code:    "missing_digit",
+			Message: "Password must contain a digit",
+		})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PolicyViolation{
+			Code:    "missing_symbol",
+			Message: "Password must contain a symbol",
+		})
+	}
+
+	if p.DisallowEmail && email != "" {
+		lowerPassword := strings.ToLower(password)
+		lowerEmail := strings.ToLower(email)
+		localPart, _, _ := strings.Cut(lowerEmail, "@")
+		if strings.Contains(lowerPassword, lowerEmail) || (len(localPart) >= 3 && strings.Contains(lowerPassword, localPart)) {
+			violations = append(violations, PolicyViolation{
+				Code:    "contains_email",
+				Message: "Password must not contain your email address",
+			})
+		}
+	}
+
+	if _, ok := p.Blocklist[strings.ToLower(password)]; ok {
+		violations = append(violations, PolicyViolation{
+			Code:    "breached",
+			Message: "Password is too common or has appeared in a data breach",
+		})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
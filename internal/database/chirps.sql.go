@@ -7,6 +7,8 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -20,7 +22,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id
 `
 
 type CreateChirpParams struct {
@@ -37,6 +39,9 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
 	)
 	return i, err
 }
@@ -52,7 +57,13 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE hidden_at IS NULL AND deleted_at IS NULL
+AND NOT EXISTS (
+    SELECT 1 FROM user_suspensions
+    WHERE user_suspensions.user_id = chirps.user_id
+    AND (user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW())
+)
 ORDER BY created_at ASC
 `
 
@@ -71,6 +82,9 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.HiddenAt,
+			&i.DeletedAt,
+			&i.ExternalID,
 		); err != nil {
 			return nil, err
 		}
@@ -86,8 +100,8 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -99,6 +113,299 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const getChirpIncludingDeleted = `-- name: GetChirpIncludingDeleted :one
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE id = $1
+`
+
+// For moderation, which has to see chirps their author has since deleted.
+func (q *Queries) GetChirpIncludingDeleted(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpIncludingDeleted, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const getChirpsForViewer = `-- name: GetChirpsForViewer :many
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE hidden_at IS NULL AND deleted_at IS NULL
+AND NOT EXISTS (
+    SELECT 1 FROM user_suspensions
+    WHERE user_suspensions.user_id = chirps.user_id
+    AND (user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW())
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (user_blocks.blocker_id = chirps.user_id AND user_blocks.blocked_id = $1)
+    OR (user_blocks.blocker_id = $1 AND user_blocks.blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE user_mutes.muter_id = $1 AND user_mutes.muted_id = chirps.user_id
+)
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetChirpsForViewer(ctx context.Context, viewerID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsForViewer, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.HiddenAt,
+			&i.DeletedAt,
+			&i.ExternalID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeletedChirp = `-- name: GetDeletedChirp :one
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE id = $1 AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) GetDeletedChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getDeletedChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const hideChirp = `-- name: HideChirp :exec
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1 AND hidden_at IS NULL
+`
+
+func (q *Queries) HideChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, hideChirp, id)
+	return err
+}
+
+const importChirp = `-- name: ImportChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, external_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+RETURNING id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id
+`
+
+type ImportChirpParams struct {
+	CreatedAt  time.Time
+	Body       string
+	UserID     uuid.NullUUID
+	ExternalID sql.NullString
+}
+
+// Imported chirps keep their original timestamp; a chirp already imported
+// with the same external ID is skipped.
+func (q *Queries) ImportChirp(ctx context.Context, arg ImportChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, importChirp,
+		arg.CreatedAt,
+		arg.Body,
+		arg.UserID,
+		arg.ExternalID,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
 	)
 	return i, err
 }
+
+const listChirpsForUser = `-- name: ListChirpsForUser :many
+SELECT id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) ListChirpsForUser(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.HiddenAt,
+			&i.DeletedAt,
+			&i.ExternalID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE deleted_at < $1::timestamp
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+`
+
+type PurgeDeletedChirpsParams struct {
+	Cutoff    time.Time
+	BatchSize int32
+}
+
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, arg PurgeDeletedChirpsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, arg.Cutoff, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, created_at, updated_at, body, user_id, hidden_at, deleted_at, external_id
+`
+
+func (q *Queries) RestoreChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.HiddenAt,
+		&i.DeletedAt,
+		&i.ExternalID,
+	)
+	return i, err
+}
+
+const restoreChirpsForUser = `-- name: RestoreChirpsForUser :exec
+UPDATE chirps
+SET deleted_at = NULL
+WHERE user_id = $1 AND deleted_at = $2
+`
+
+type RestoreChirpsForUserParams struct {
+	UserID    uuid.NullUUID
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) RestoreChirpsForUser(ctx context.Context, arg RestoreChirpsForUserParams) error {
+	_, err := q.db.ExecContext(ctx, restoreChirpsForUser, arg.UserID, arg.DeletedAt)
+	return err
+}
+
+const softDeleteChirp = `-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET deleted_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, softDeleteChirp, id)
+	return err
+}
+
+const softDeleteChirpsForUser = `-- name: SoftDeleteChirpsForUser :exec
+UPDATE chirps
+SET deleted_at = $2
+WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+type SoftDeleteChirpsForUserParams struct {
+	UserID    uuid.NullUUID
+	DeletedAt sql.NullTime
+}
+
+// Chirps deleted along with their author share the author's deleted_at, so
+// restoring the account brings back only those.
+func (q *Queries) SoftDeleteChirpsForUser(ctx context.Context, arg SoftDeleteChirpsForUserParams) error {
+	_, err := q.db.ExecContext(ctx, softDeleteChirpsForUser, arg.UserID, arg.DeletedAt)
+	return err
+}
+
+const unhideChirp = `-- name: UnhideChirp :exec
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) UnhideChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, unhideChirp, id)
+	return err
+}
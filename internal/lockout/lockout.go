@@ -0,0 +1,168 @@
+package lockout
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy controls how quickly repeated failures are slowed down and locked out.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay applies
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure beyond FreeAttempts; it doubles with each further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold is the number of failures that triggers a lockout of LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long after the last failure the count is forgotten
+	Window time.Duration
+}
+
+// Status is a snapshot of one tracked key.
+type Status struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"last_failure"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	Locked       bool      `json:"locked"`
+}
+
+type entry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// Tracker counts failed attempts per key (an account or a client IP) in memory.
+type Tracker struct {
+	mu      sync.Mutex
+	policy  Policy
+	entries map[string]*entry
+	Now     func() time.Time
+}
+
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		policy:  policy,
+		entries: make(map[string]*entry),
+		Now:     time.Now,
+	}
+}
+
+// Check reports whether key may attempt now, and if not, how long until it may.
+func (t *Tracker) Check(key string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.get(key)
+	if e == nil {
+		return 0, true
+	}
+	if wait := e.blockedUntil.Sub(t.Now()); wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+// Failure records a failed attempt and returns how long key must now wait.
+func (t *Tracker) Failure(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+	e := t.get(key)
+	if e == nil {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	switch {
+	case t.policy.LockoutThreshold > 0 && e.failures >= t.policy.LockoutThreshold:
+		e.locked = true
+		e.blockedUntil = now.Add(t.policy.LockoutDuration)
+	case e.failures > t.policy.FreeAttempts:
+		delay := t.policy.BaseDelay << (e.failures - t.policy.FreeAttempts - 1)
+		if delay > t.policy.MaxDelay || delay <= 0 {
+			delay = t.policy.MaxDelay
+		}
+		e.blockedUntil = now.Add(delay)
+	}
+	return max(e.blockedUntil.Sub(now), 0)
+}
+
+// Success forgets the failures recorded for key.
+func (t *Tracker) Success(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// Clear removes key and reports whether it was being tracked.
+func (t *Tracker) Clear(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// List returns every key with recent failures, optionally only those currently
+// blocked, sorted by key.  Expired entries are pruned as a side effect.
+func (t *Tracker) List(blockedOnly bool) []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+	statuses := []Status{}
+	for key := range t.entries {
+		e := t.get(key)
+		if e == nil {
+			continue
+		}
+		blocked := e.blockedUntil.After(now)
+		if blockedOnly && !blocked {
+			continue
+		}
+		statuses = append(statuses, Status{
+			Key:          key,
+			Failures:     e.failures,
+			LastFailure:  e.lastFailure,
+			BlockedUntil: e.blockedUntil,
+			Locked:       e.locked && blocked,
+		})
+	}
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return statuses
+}
+
+// Prune drops every expired entry.  Call it periodically so keys that are never
+// seen again don't accumulate.
+func (t *Tracker) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.entries {
+		t.get(key)
+	}
+}
+
+// get returns the live entry for key, dropping it if it has expired.  t.mu must be held.
+func (t *Tracker) get(key string) *entry {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	now := t.Now()
+	if now.Sub(e.lastFailure) > t.policy.Window && !e.blockedUntil.After(now) {
+		delete(t.entries, key)
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type cachedIdentity struct {
+	identity string
+	expires  time.Time
+}
+
+// IdentityCache remembers what credentials resolved to for TTL, so the
+// limiter doesn't look the same credential up on every request.  Only
+// credentials that resolved should be cached, which bounds its size.
+type IdentityCache struct {
+	mu         sync.Mutex
+	identities map[string]cachedIdentity
+	TTL        time.Duration
+	Now        func() time.Time
+}
+
+func NewIdentityCache(ttl time.Duration) *IdentityCache {
+	return &IdentityCache{
+		identities: make(map[string]cachedIdentity),
+		TTL:        ttl,
+		Now:        time.Now,
+	}
+}
+
+func (c *IdentityCache) Get(credential string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.identities[credential]
+	if !ok || !c.Now().Before(cached.expires) {
+		return "", false
+	}
+	return cached.identity, true
+}
+
+func (c *IdentityCache) Put(credential, identity string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.identities[credential] = cachedIdentity{identity: identity, expires: c.Now().Add(c.TTL)}
+}
+
+// Prune drops expired entries
+func (c *IdentityCache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Now()
+	for credential, cached := range c.identities {
+		if !now.Before(cached.expires) {
+			delete(c.identities, credential)
+		}
+	}
+}
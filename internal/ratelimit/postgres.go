@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so every
+// instance behind a load balancer shares the same limits.  Each take is a
+// single atomic upsert.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{
+		db: db,
+	}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	row, err := s.db.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key:        key,
+		Capacity:   float64(policy.Limit),
+		RefillRate: policy.rate(),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(row.Allowed, row.Tokens, policy), nil
+}
+
+// Prune deletes buckets untouched for longer than maxIdle.
+func (s *PostgresStore) Prune(ctx context.Context, maxIdle time.Duration) error {
+	return s.db.DeleteIdleRateLimitBuckets(ctx, time.Now().Add(-maxIdle))
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy allows Limit requests per Period, refilled continuously (a token
+// bucket holding at most Limit tokens).
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result describes the state of a bucket after an attempt to take a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed; zero if Allowed
+	RetryAfter time.Duration
+}
+
+// Store holds token buckets.  Implementations shared between instances (such
+// as PostgresStore) make limits apply across the whole deployment.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// newResult computes the response headers' values from the tokens left after a take.
+func newResult(allowed bool, tokens float64, policy Policy) Result {
+	rate := policy.rate()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     policy.Limit,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     time.Duration((float64(policy.Limit) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps buckets in process memory.  Limits are per instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	Now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		Now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens:  float64(policy.Limit),
+			updated: now,
+		}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(policy.Limit), b.tokens+now.Sub(b.updated).Seconds()*policy.rate())
+	b.updated = now
+	if b.tokens < 1 {
+		return newResult(false, b.tokens, policy), nil
+	}
+	b.tokens--
+	return newResult(true, b.tokens, policy), nil
+}
+
+// Prune drops buckets untouched for longer than maxIdle, which have long since
+// refilled and are equivalent to a missing bucket.
+func (s *MemoryStore) Prune(maxIdle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > maxIdle {
+			delete(s.buckets, key)
+		}
+	}
+}
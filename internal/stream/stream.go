@@ -0,0 +1,133 @@
+package stream
+
+import (
+	"sync"
+)
+
+// Event is one message on a stream.
+type Event[T any] struct {
+	ID   string
+	Type string
+	Data T
+}
+
+// Hub fans events out to subscribers and keeps the most recent ones, so a
+// subscriber that reconnects can pick up where it left off.  Publishing never
+// blocks: a subscriber that falls a full buffer behind is dropped, and can
+// reconnect to catch up from the replay buffer.
+type Hub[T any] struct {
+	mu         sync.Mutex
+	replay     []Event[T]
+	replaySize int
+	bufferSize int
+	subs       map[*Subscription[T]]struct{}
+	closed     bool
+}
+
+// Subscription receives the events published after it was made.
+type Subscription[T any] struct {
+	hub    *Hub[T]
+	events chan Event[T]
+}
+
+// NewHub makes a hub replaying up to replaySize events, with bufferSize
+// events of slack for each subscriber.
+func NewHub[T any](replaySize, bufferSize int) *Hub[T] {
+	return &Hub[T]{
+		replaySize: replaySize,
+		bufferSize: bufferSize,
+		subs:       map[*Subscription[T]]struct{}{},
+	}
+}
+
+// Publish records an event for replay and hands it to every subscriber.
+func (h *Hub[T]) Publish(e Event[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	if len(h.replay) == h.replaySize && h.replaySize > 0 {
+		copy(h.replay, h.replay[1:])
+		h.replay = h.replay[:len(h.replay)-1]
+	}
+	if h.replaySize > 0 {
+		h.replay = append(h.replay, e)
+	}
+
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			// Too far behind; closing the channel tells the subscriber
+			h.drop(sub)
+		}
+	}
+}
+
+// Subscribe starts a subscription.  If lastEventID is set, the events
+// published after it are returned to be sent first; ok is false if that event
+// is no longer in the replay buffer, meaning the subscriber missed events.
+func (h *Hub[T]) Subscribe(lastEventID string) (sub *Subscription[T], backlog []Event[T], ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &Subscription[T]{
+		hub:    h,
+		events: make(chan Event[T], h.bufferSize),
+	}
+	if h.closed {
+		close(sub.events)
+		return sub, nil, true
+	}
+	h.subs[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	for i, e := range h.replay {
+		if e.ID == lastEventID {
+			return sub, append([]Event[T](nil), h.replay[i+1:]...), true
+		}
+	}
+	return sub, nil, false
+}
+
+// Close ends every subscription and stops new ones, for server shutdown.
+func (h *Hub[T]) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub)
+	}
+}
+
+// Subscribers is the number of open subscriptions.
+func (h *Hub[T]) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// drop must be called with h.mu held.
+func (h *Hub[T]) drop(sub *Subscription[T]) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Events delivers the subscription's events.  It is closed when the
+// subscriber is dropped for falling behind or the hub closes.
+func (s *Subscription[T]) Events() <-chan Event[T] {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription[T]) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.drop(s)
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-Chirpy-Event"
+	DeliveryHeader  = "X-Chirpy-Delivery"
+	TimestampHeader = "X-Chirpy-Timestamp"
+	SignatureHeader = "X-Chirpy-Signature"
+)
+
+// MakeSecret returns a new secret for signing a webhook's deliveries.
+func MakeSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// Sign returns the signature of a delivery sent at timestamp: "sha256=" and
+// the hex HMAC-SHA256, keyed with secret, of the Unix timestamp, a "." and the
+// body.  Covering the timestamp lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature on a delivery and that it was sent within
+// tolerance of now, as a receiver should.
+func Verify(secret string, headers http.Header, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(headers.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("delivery has no valid timestamp")
+	}
+	timestamp := time.Unix(unix, 0)
+	if age := time.Since(timestamp); age > tolerance || age < -tolerance {
+		return errors.New("delivery timestamp is outside the tolerance")
+	}
+	want := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(headers.Get(SignatureHeader)), []byte(want)) {
+		return errors.New("delivery signature does not match")
+	}
+	return nil
+}
+
+// Backoff is how long to wait before retrying a delivery that has failed
+// attempts times: base, doubling with each further failure, up to max.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
+// NewClient returns a client for sending deliveries that doesn't follow
+// redirects.  With publicOnly it refuses to connect to loopback, private and
+// link-local addresses, so webhooks can't be pointed at internal services;
+// the check is made on the address dialled, so DNS can't be used to dodge it.
+func NewClient(timeout time.Duration, publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if publicOnly {
+		dialer.Control = refusePrivate
+		// A proxy would be dialled in place of the receiver
+		transport.Proxy = nil
+	}
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsUnspecified() || addr.IsMulticast() {
+		return fmt.Errorf("refusing to deliver to non-public address %s", addr)
+	}
+	return nil
+}
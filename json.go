@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+func respondWithJSON(wrt http.ResponseWriter, code int, payload any) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error marshalling JSON", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.WriteHeader(code)
+	wrt.Write(dat)
+}
+
+func respondWithError(wrt http.ResponseWriter, code int, msg string) {
+	respondWithJSON(wrt, code, chirpError{
+		Error: msg,
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/lockout"
+)
+
+func newTestTracker(now *time.Time) *lockout.Tracker {
+	tracker := lockout.NewTracker(lockout.Policy{
+		FreeAttempts:     2,
+		BaseDelay:        time.Second,
+		MaxDelay:         8 * time.Second,
+		LockoutThreshold: 6,
+		LockoutDuration:  time.Minute,
+		Window:           time.Hour,
+	})
+	tracker.Now = func() time.Time { return *now }
+	return tracker
+}
+
+func TestLockoutExponentialBackoff(t *testing.T) {
+	now := time.Unix(1755361192, 0)
+	tracker := newTestTracker(&now)
+	key := "email:saul@bettercall.com"
+
+	expected := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, delay := range expected {
+		if wait := tracker.Failure(key); wait != delay {
+			t.Errorf("failure %d: expected delay %v, got %v", i+1, delay, wait)
+		}
+	}
+
+	if _, ok := tracker.Check(key); ok {
+		t.Errorf("expected key to be backed off")
+	}
+	now = now.Add(4 * time.Second)
+	if _, ok := tracker.Check(key); !ok {
+		t.Errorf("expected key to be allowed after backoff elapsed")
+	}
+}
+
+func TestLockoutThreshold(t *testing.T) {
+	now := time.Unix(1755361192, 0)
+	tracker := newTestTracker(&now)
+	key := "ip:10.0.0.1"
+
+	for range 6 {
+		tracker.Failure(key)
+	}
+	wait, ok := tracker.Check(key)
+	if ok || wait != time.Minute {
+		t.Errorf("expected a one minute lockout, got ok=%v wait=%v", ok, wait)
+	}
+
+	statuses := tracker.List(true)
+	if len(statuses) != 1 || !statuses[0].Locked || statuses[0].Failures != 6 {
+		t.Errorf("unexpected lockout list %+v", statuses)
+	}
+
+	if !tracker.Clear(key) {
+		t.Errorf("expected lockout to be cleared")
+	}
+	if _, ok := tracker.Check(key); !ok {
+		t.Errorf("expected key to be allowed after clearing")
+	}
+}
+
+func TestLockoutSuccessAndWindow(t *testing.T) {
+	now := time.Unix(1755361192, 0)
+	tracker := newTestTracker(&now)
+
+	tracker.Failure("a")
+	tracker.Failure("a")
+	tracker.Success("a")
+	if wait := tracker.Failure("a"); wait != 0 {
+		t.Errorf("expected failures to reset after success, got delay %v", wait)
+	}
+
+	tracker.Failure("b")
+	tracker.Failure("b")
+	now = now.Add(2 * time.Hour)
+	tracker.Prune()
+	if statuses := tracker.List(false); len(statuses) != 0 {
+		t.Errorf("expected stale entries to be pruned, got %+v", statuses)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients and proxies
+const maxRequestIDLength = 128
+
+// loadLogger builds the process logger from LOG_LEVEL (debug, info, warn or
+// error; default info) and LOG_FORMAT (text or json; default text).
+func loadLogger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", s, err)
+		}
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactSecrets,
+	}
+	var handler slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown LOG_FORMAT %q", format)
+	}
+	return slog.New(requestHandler{handler}), nil
+}
+
+// redactSecrets blanks out attributes whose key says they hold a credential,
+// so a careless log call can't leak one.
+func redactSecrets(_ []string, attr slog.Attr) slog.Attr {
+	if isSecretKey(attr.Key) {
+		return slog.String(attr.Key, "[REDACTED]")
+	}
+	return attr
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"password", "secret", "token", "authorization", "cookie"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	switch key {
+	case "api_key", "code", "recovery_code", "otp":
+		return true
+	}
+	return false
+}
+
+// requestLog is what the logging middleware knows about a request.  Handlers
+// fill in the user once they have authenticated one.
+type requestLog struct {
+	id string
+
+	mu     sync.Mutex
+	userID uuid.UUID
+}
+
+type requestLogKey struct{}
+
+func requestLogFromContext(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// setRequestUser records who a request was made by, for its log lines
+func setRequestUser(ctx context.Context, userID uuid.UUID) {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		rl.mu.Lock()
+		rl.userID = userID
+		rl.mu.Unlock()
+	}
+}
+
+// requestHandler adds the request and trace IDs to every record logged with
+// a request's context.
+type requestHandler struct {
+	slog.Handler
+}
+
+func (h requestHandler) Handle(ctx context.Context, record slog.Record) error {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		record.AddAttrs(slog.String("request_id", rl.id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
+
+// validRequestID accepts IDs made of characters that are safe to echo back
+// in a header and write into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// middlewareLogging gives each request an ID, taking the caller's
+// X-Request-ID if it has a sensible one, and writes an access log line once
+// it has been served.  Only the route pattern is logged, never the raw URL,
+// since query strings can carry codes and tokens.
+func middlewareLogging(mux *http.ServeMux, next http.Handler) http.Handler {
+	f := func(wrt http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		wrt.Header().Set(requestIDHeader, id)
+
+		rl := &requestLog{id: id}
+		ctx := context.WithValue(req.Context(), requestLogKey{}, rl)
+		route := routePattern(mux, req)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: wrt}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = 200
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Int("size", rec.size),
+			slog.Duration("latency", time.Since(start)),
+		}
+		rl.mu.Lock()
+		if rl.userID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", rl.userID.String()))
+		}
+		rl.mu.Unlock()
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	}
+	return http.HandlerFunc(f)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadLogger(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_FORMAT", "json")
+	buf := &bytes.Buffer{}
+	logger, err := loadLogger(buf)
+	if err != nil {
+		t.Fatalf("error loading logger: %v", err)
+	}
+	logger.Info("quiet")
+	logger.Warn("loud")
+	if strings.Contains(buf.String(), "quiet") || !strings.Contains(buf.String(), `"msg":"loud"`) {
+		t.Errorf("expected only the warning as JSON, got %s", buf.String())
+	}
+
+	t.Setenv("LOG_FORMAT", "xml")
+	if _, err := loadLogger(buf); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	t.Setenv("LOG_FORMAT", "")
+	t.Setenv("LOG_LEVEL", "chatty")
+	if _, err := loadLogger(buf); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestLoggerRedactsSecrets(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := loadLogger(buf)
+	if err != nil {
+		t.Fatalf("error loading logger: %v", err)
+	}
+	logger.Info("login",
+		"password", "Kettleman2008",
+		"refresh_token", "abc123",
+		"Authorization", "Bearer xyz",
+		"code", "123456",
+		"api_key_id", "kept",
+		"email", "saul@bettercall.com",
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"Kettleman2008", "abc123", "xyz", "123456"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted, got %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"kept", "saul@bettercall.com"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("expected %q in the log, got %s", kept, out)
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := map[string]bool{
+		"":                          false,
+		"3f2a9c":                    true,
+		"req-1.2_3:4":               true,
+		"has space":                 false,
+		"line\nbreak":               false,
+		strings.Repeat("a", 129):    false,
+		strings.Repeat("a", 128):    true,
+		"<script>alert(1)</script>": false,
+	}
+	for id, want := range tests {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestMiddlewareLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	t.Setenv("LOG_FORMAT", "json")
+	logger, err := loadLogger(buf)
+	if err != nil {
+		t.Fatalf("error loading logger: %v", err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	userID := uuid.New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(wrt http.ResponseWriter, req *http.Request) {
+		setRequestUser(req.Context(), userID)
+		slog.InfoContext(req.Context(), "inside handler")
+		respondWithError(wrt, 404, "Chirp not found")
+	})
+	handler := middlewareLogging(mux, mux)
+
+	t.Run("propagates request ID", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/api/chirps/123?token=hunter2", nil)
+		req.Header.Set(requestIDHeader, "upstream-42")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get(requestIDHeader); got != "upstream-42" {
+			t.Errorf("expected the request ID to be echoed, got %q", got)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf.String())
+		}
+		for _, line := range lines {
+			if !strings.Contains(line, `"request_id":"upstream-42"`) {
+				t.Errorf("expected the request ID on every line, got %s", line)
+			}
+		}
+
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+			t.Fatalf("error decoding log line: %v", err)
+		}
+		if entry["route"] != "GET /api/chirps/{chirpID}" || entry["status"] != float64(404) || entry["user_id"] != userID.String() {
+			t.Errorf("unexpected access log entry: %v", entry)
+		}
+		if entry["size"].(float64) == 0 {
+			t.Errorf("expected the response size to be logged, got %v", entry)
+		}
+		if strings.Contains(buf.String(), "hunter2") {
+			t.Errorf("expected the query string to stay out of the logs, got %s", buf.String())
+		}
+	})
+
+	t.Run("replaces invalid request ID", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/api/chirps/123", nil)
+		req.Header.Set(requestIDHeader, "bad id")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(requestIDHeader)
+		if got == "" || got == "bad id" {
+			t.Errorf("expected a generated request ID, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+func accountLockoutKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginWait reports whether the account and the client IP may attempt to log
+// in now, and if not, how long until they may.
+func (cfg *apiConfig) loginWait(req *http.Request, email string) (time.Duration, bool) {
+	wait, ok := cfg.accountLockouts.Check(accountLockoutKey(email))
+	if ipWait, ipOk := cfg.ipLockouts.Check(ipLockoutKey(cfg.clientIP(req))); !ipOk {
+		ok = false
+		wait = max(wait, ipWait)
+	}
+	return wait, ok
+}
+
+// checkLoginAllowed writes a 429 and returns false if either the account or
+// the client IP is currently backed off or locked out.
+func (cfg *apiConfig) checkLoginAllowed(wrt http.ResponseWriter, req *http.Request, email string) bool {
+	wait, ok := cfg.loginWait(req, email)
+	if ok {
+		return true
+	}
+
+	wrt.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	respondWithError(wrt, 429, "Too many failed login attempts, try again later")
+	return false
+}
+
+// recordLoginFailure counts a failed attempt against the account and client IP.
+func (cfg *apiConfig) recordLoginFailure(req *http.Request, email string) {
+	loginFailures.Inc()
+	cfg.accountLockouts.Failure(accountLockoutKey(email))
+	cfg.ipLockouts.Failure(ipLockoutKey(cfg.clientIP(req)))
+}
+
+// loginFailed records a failed attempt and writes the same response whether
+// or not the account exists.
+func (cfg *apiConfig) loginFailed(wrt http.ResponseWriter, req *http.Request, email string) {
+	cfg.recordLoginFailure(req, email)
+	respondWithError(wrt, 401, "incorrect email or password")
+}
+
+// checkCredentials verifies an email and password without writing a response,
+// spending the same time on unknown emails as on wrong passwords.  Failures
+// are recorded against the lockout trackers; the caller resets the account's
+// count once any second factor has been checked too.
+func (cfg *apiConfig) checkCredentials(req *http.Request, email, password string) (database.User, bool) {
+	user, err := cfg.db.GetUserByEmail(req.Context(), email)
+	if err != nil {
+		cfg.hashers.Verify(password, cfg.dummyHash)
+		cfg.recordLoginFailure(req, email)
+		return database.User{}, false
+	}
+	if _, err := cfg.verifyPassword(password, user.HashedPassword); err != nil {
+		cfg.recordLoginFailure(req, email)
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// verifyPassword checks password against a user's hash.  Hashes no hasher
+// recognizes, such as unusablePasswordHash on accounts that sign in through an
+// identity provider, are only rejected after checking the dummy hash, so
+// timing doesn't reveal which accounts have no password.
+func (cfg *apiConfig) verifyPassword(password, hash string) (needsRehash bool, err error) {
+	needsRehash, err = cfg.hashers.Verify(password, hash)
+	if errors.Is(err, auth.ErrUnknownHash) {
+		cfg.hashers.Verify(password, cfg.dummyHash)
+	}
+	return needsRehash, err
+}
+
+func (cfg *apiConfig) handlerListLockouts(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleAdmin); !ok {
+		return
+	}
+
+	blockedOnly := req.URL.Query().Get("all") != "true"
+	respondWithJSON(wrt, 200, lockoutList{
+		Accounts: cfg.accountLockouts.List(blockedOnly),
+		IPs:      cfg.ipLockouts.List(blockedOnly),
+	})
+}
+
+func (cfg *apiConfig) handlerClearLockout(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleAdmin); !ok {
+		return
+	}
+
+	key := req.PathValue("key")
+	cleared := cfg.accountLockouts.Clear(key) || cfg.ipLockouts.Clear(key)
+	if !cleared {
+		respondWithError(wrt, 404, fmt.Sprintf("No lockout found for %s", key))
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// pruneLockouts periodically discards expired failure counts.
+func (cfg *apiConfig) pruneLockouts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg.accountLockouts.Prune()
+		cfg.ipLockouts.Prune()
+	}
+}
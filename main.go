@@ -1,35 +1,47 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/mail"
 	"os"
+	"os/signal"
 	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/nfongster/chirpy/internal/auth"
 	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/lockout"
+	"github.com/nfongster/chirpy/internal/mailer"
+	"github.com/nfongster/chirpy/internal/ratelimit"
+	"github.com/nfongster/chirpy/internal/stream"
+	"github.com/nfongster/chirpy/internal/webhook"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
-// TODO: add other middleware (checking JWT, etc.)
-func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
-	f := func(wrt http.ResponseWriter, req *http.Request) {
-		cfg.fileserverHits.Add(1)
-		next.ServeHTTP(wrt, req)
-	}
-	return http.HandlerFunc(f)
-}
-
 func validateChirp(chirp string) bool {
 	return len(chirp) <= 140
 }
 
+// validateEmail accepts a bare address such as "saul@bettercall.com", rejecting
+// display names ("Saul <saul@bettercall.com>") and anything net/mail can't parse.
+func validateEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+	return addr.Address == email
+}
+
 func removeProfanities(chirp string) string {
 	profanities := []string{
 		"kerfuffle", "sharbert", "fornax",
@@ -45,44 +57,185 @@ func removeProfanities(chirp string) string {
 }
 
 func main() {
-	fmt.Println("Starting chirpy server...")
-
 	if err := godotenv.Load(); err != nil {
-		fmt.Printf("error loading db string: %v\n", err)
+		slog.Error("error loading db string", "error", err)
 		os.Exit(1)
 	}
+
+	logger, err := loadLogger(os.Stderr)
+	if err != nil {
+		slog.Error("error loading logging config", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := loadTracing(context.Background())
+	if err != nil {
+		slog.Error("error loading tracing config", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	dbURL := os.Getenv("DB_URL")
 	platform := os.Getenv("PLATFORM")
 	secret := os.Getenv("SECRET")
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Chirpy <no-reply@chirpy.local>"
+	}
 
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
-		fmt.Printf("error opening db: %v\n", err)
+		slog.Error("error opening db", "error", err)
 		os.Exit(1)
 	}
-	dbQueries := database.New(db)
+	dbQueries := newTracedQueries(db)
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db, "chirpy"))
+
+	// "chirpy import ..." loads an archive of chirps instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(&apiConfig{db: dbQueries, dbConn: db}, os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	slog.Info("Starting chirpy server", "addr", ":8080")
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		slog.Error("error loading password policy", "error", err)
+		os.Exit(1)
+	}
+
+	hashers, err := loadPasswordHashers()
+	if err != nil {
+		slog.Error("error loading password hashing config", "error", err)
+		os.Exit(1)
+	}
+
+	dummyHash, err := hashers.Hash("chirpy-dummy-password")
+	if err != nil {
+		slog.Error("error creating dummy password hash", "error", err)
+		os.Exit(1)
+	}
+
+	trustedProxies, err := ratelimit.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		slog.Error("error parsing TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
+
+	var rateLimiter ratelimit.Store
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		rateLimiter = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimiter = ratelimit.NewPostgresStore(dbQueries)
+	default:
+		slog.Error("unknown RATE_LIMIT_STORE", "store", store)
+		os.Exit(1)
+	}
+
+	ssoProviders, err := loadSSOProviders(context.Background())
+	if err != nil {
+		slog.Error("error loading identity providers", "error", err)
+		os.Exit(1)
+	}
+
+	reportThreshold, err := loadReportThreshold()
+	if err != nil {
+		slog.Error("error loading moderation config", "error", err)
+		os.Exit(1)
+	}
+
+	deletionGracePeriod, err := loadDeletionGracePeriod()
+	if err != nil {
+		slog.Error("error loading deletion config", "error", err)
+		os.Exit(1)
+	}
+
+	drainDelay, err := loadShutdownDrainDelay()
+	if err != nil {
+		slog.Error("error loading shutdown config", "error", err)
+		os.Exit(1)
+	}
+
+	// Outgoing mail goes to SMTP in production and to stdout (or MAIL_LOG) otherwise
+	var m mailer.Mailer
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		smtpPort := os.Getenv("SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+		m = mailer.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	} else if mailLog := os.Getenv("MAIL_LOG"); mailLog != "" {
+		f, err := os.OpenFile(mailLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			slog.Error("error opening mail log", "error", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		m = mailer.NewLogMailer(f, mailFrom)
+	} else {
+		m = mailer.NewLogMailer(os.Stdout, mailFrom)
+	}
 
 	mux := http.NewServeMux()
 	apiCfg := &apiConfig{
-		db:     dbQueries,
-		secret: secret,
+		db:             dbQueries,
+		dbConn:         db,
+		secret:         secret,
+		mailer:         m,
+		passwordPolicy: passwordPolicy,
+		hashers:        hashers,
+		totp:           auth.NewTOTP("Chirpy"),
+		dummyHash:      dummyHash,
+		accountLockouts: lockout.NewTracker(lockout.Policy{
+			FreeAttempts:     3,
+			BaseDelay:        time.Second,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: 10,
+			LockoutDuration:  15 * time.Minute,
+			Window:           time.Hour,
+		}),
+		// Looser per-IP limits, since many users can share an address behind NAT
+		ipLockouts: lockout.NewTracker(lockout.Policy{
+			FreeAttempts:     20,
+			BaseDelay:        time.Second,
+			MaxDelay:         time.Minute,
+			LockoutThreshold: 100,
+			LockoutDuration:  15 * time.Minute,
+			Window:           time.Hour,
+		}),
+		rateLimiter:         rateLimiter,
+		apiKeyIdentities:    ratelimit.NewIdentityCache(5 * time.Minute),
+		trustedProxies:      trustedProxies,
+		ssoProviders:        ssoProviders,
+		reportThreshold:     reportThreshold,
+		deletionGracePeriod: deletionGracePeriod,
+		exportWake:          make(chan struct{}, 1),
+		chirpHub:            stream.NewHub[Chirp](chirpStreamReplaySize, chirpStreamBufferSize),
+		webhookClient:       webhook.NewClient(webhookTimeout, false),
+		userWebhookClient:   webhook.NewClient(webhookTimeout, true),
 	}
+	go apiCfg.pruneLockouts(10 * time.Minute)
+	go apiCfg.pruneRateLimits(10 * time.Minute)
+	go apiCfg.pruneOAuthLoginStates(10 * time.Minute)
+	go apiCfg.pruneOAuthGrants(time.Hour)
+	go apiCfg.pruneSuspensions(time.Hour)
+	go apiCfg.purgeDeleted(10 * time.Minute)
+	go apiCfg.processDataExports(time.Minute)
+	go apiCfg.pruneDataExports(time.Hour)
+	go apiCfg.processWebhookDeliveries(5 * time.Second)
+	go apiCfg.pruneWebhookDeliveries(time.Hour)
+	go apiCfg.listenChirpEvents(pq.NewListener(dbURL, 10*time.Second, time.Minute, listenerEventLogger))
 
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(".")))))
 
-	mux.HandleFunc("GET /api/healthz", func(wrt http.ResponseWriter, _ *http.Request) {
-		wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		wrt.WriteHeader(200)
-		wrt.Write([]byte("OK\n"))
-	})
+	mux.HandleFunc("GET /api/healthz", handlerLiveness)
+	mux.HandleFunc("GET /api/livez", handlerLiveness)
+	mux.HandleFunc("GET /api/readyz", apiCfg.handlerReadiness)
 
-	mux.HandleFunc("GET /admin/metrics", func(wrt http.ResponseWriter, _ *http.Request) {
-		wrt.Header().Set("Content-Type", "text/html; charset=utf-8")
-		wrt.WriteHeader(200)
-		hits := apiCfg.fileserverHits.Load()
-		html := fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", hits)
-		wrt.Write([]byte(html))
-	})
+	mux.Handle("GET /metrics", handlerPrometheusMetrics())
+	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerAdminMetrics)
 
 	mux.HandleFunc("POST /admin/reset", func(wrt http.ResponseWriter, req *http.Request) {
 		wrt.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -91,208 +244,224 @@ func main() {
 			return
 		}
 
-		apiCfg.fileserverHits.Swap(0)
+		apiCfg.resetFileserverHits()
 		if err := apiCfg.db.DeleteAllUsers(req.Context()); err != nil {
-			fmt.Printf("Error deleting all users from db: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error deleting all users from db", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
 		wrt.WriteHeader(200)
 	})
 
+	mux.HandleFunc("GET /admin/lockouts", apiCfg.handlerListLockouts)
+	mux.HandleFunc("DELETE /admin/lockouts/{key}", apiCfg.handlerClearLockout)
+
+	mux.HandleFunc("GET /admin/suspensions", apiCfg.handlerListSuspensions)
+	mux.HandleFunc("PUT /admin/users/{userID}/suspension", apiCfg.handlerSuspendUser)
+	mux.HandleFunc("DELETE /admin/users/{userID}/suspension", apiCfg.handlerUnsuspendUser)
+
+	mux.HandleFunc("DELETE /admin/users/{userID}", apiCfg.handlerDeleteUser)
+	mux.HandleFunc("POST /admin/users/{userID}/restore", apiCfg.handlerRestoreUser)
+
 	mux.HandleFunc("POST /api/users", func(wrt http.ResponseWriter, req *http.Request) {
 		// Handle request
 		decoder := json.NewDecoder(req.Body)
 		params := userParameters{}
 		if err := decoder.Decode(&params); err != nil {
-			fmt.Printf("Error decoding parameters: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
 
 		// Write response
+		if !validateEmail(params.Email) {
+			wrt.WriteHeader(400)
+			wrt.Write([]byte("Invalid email address!"))
+			return
+		}
 		if params.Password == "" {
 			wrt.WriteHeader(400)
 			wrt.Write([]byte("No password was supplied!"))
 			return
 		}
-		hashedPassword, err := auth.HashPassword(params.Password)
-		if err != nil {
-			fmt.Printf("Error hashing password: %v", err)
-			wrt.WriteHeader(500)
+		if !apiCfg.checkPasswordPolicy(wrt, params.Password, params.Email) {
 			return
 		}
-		user, err := apiCfg.db.CreateUser(req.Context(), database.CreateUserParams{
-			Email:          params.Email,
-			HashedPassword: hashedPassword,
-		})
+		hashedPassword, err := apiCfg.hashers.Hash(params.Password)
 		if err != nil {
-			fmt.Printf("Error querying user for email %s: %s\n", params.Email, err)
+			slog.ErrorContext(req.Context(), "Error hashing password", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-
-		// Convert DB query struct to JSON struct
-		dat, err := json.Marshal(User{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-		})
+		tx, err := apiCfg.dbConn.BeginTx(req.Context(), nil)
 		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-
-		wrt.Header().Set("Content-Type", "application/json")
-		wrt.WriteHeader(201)
-		wrt.Write(dat)
-	})
-
-	mux.HandleFunc("PUT /api/users", func(wrt http.ResponseWriter, req *http.Request) {
-		// Check JWT first
-		tokenString, err := auth.GetBearerToken(req.Header)
-		if err != nil {
-			wrt.WriteHeader(401)
-			return
-		}
-		userId, err := auth.ValidateJWT(tokenString, apiCfg.secret)
+		defer tx.Rollback()
+		qtx := apiCfg.withTx(tx)
+		user, err := qtx.CreateUser(req.Context(), database.CreateUserParams{
+			Email:          params.Email,
+			HashedPassword: hashedPassword,
+		})
 		if err != nil {
-			wrt.WriteHeader(401)
-			return
-		}
-
-		// Get new email and password
-		decoder := json.NewDecoder(req.Body)
-		params := userParameters{}
-		if err := decoder.Decode(&params); err != nil {
-			fmt.Printf("Error decoding parameters: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error querying user for email", "email", params.Email, "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-		if params.Password == "" {
-			wrt.WriteHeader(400)
-			wrt.Write([]byte("No password was supplied!"))
-			return
-		}
-
-		// Hash the new password
-		hashedPassword, err := auth.HashPassword(params.Password)
-		if err != nil {
-			fmt.Printf("Error hashing password: %v", err)
+		if err := queueWebhookEvent(req.Context(), qtx, webhookEventUserCreated, uuid.NullUUID{UUID: user.ID, Valid: true}, webhookUser{
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+		}); err != nil {
+			slog.ErrorContext(req.Context(), "Error queueing webhook event", "type", webhookEventUserCreated, "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-		// Update user info in DB
-		user, err := apiCfg.db.UpdateUser(req.Context(), database.UpdateUserParams{
-			ID:             userId,
-			Email:          params.Email,
-			HashedPassword: hashedPassword,
-		})
-		// Send response
-		if err != nil {
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(req.Context(), "Error committing user", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
+		if err := apiCfg.sendVerificationEmail(req.Context(), user); err != nil {
+			slog.ErrorContext(req.Context(), "Error sending verification email", "email", user.Email, "error", err)
+		}
 
+		// Convert DB query struct to JSON struct
 		dat, err := json.Marshal(User{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
 		})
 		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
 
 		wrt.Header().Set("Content-Type", "application/json")
-		wrt.WriteHeader(200)
+		wrt.WriteHeader(201)
 		wrt.Write(dat)
 	})
 
+	mux.HandleFunc("DELETE /api/users", apiCfg.handlerDeleteAccount)
+	mux.HandleFunc("POST /api/users/restore", apiCfg.handlerRestoreAccount)
+
+	mux.HandleFunc("POST /api/users/export", apiCfg.handlerRequestDataExport)
+	mux.HandleFunc("GET /api/users/export/{exportID}", apiCfg.handlerGetDataExport)
+
+	mux.HandleFunc("GET /api/users/blocks", apiCfg.handlerListBlocks)
+	mux.HandleFunc("PUT /api/users/{userID}/block", apiCfg.handlerBlockUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.handlerUnblockUser)
+	mux.HandleFunc("GET /api/users/mutes", apiCfg.handlerListMutes)
+	mux.HandleFunc("PUT /api/users/{userID}/mute", apiCfg.handlerMuteUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", apiCfg.handlerUnmuteUser)
+
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyUser)
+
+	mux.HandleFunc("GET /api/notifications", apiCfg.handlerListNotifications)
+	mux.HandleFunc("GET /api/notifications/unread", apiCfg.handlerUnreadNotifications)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.handlerMarkAllNotificationsRead)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiCfg.handlerMarkNotificationRead)
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.handlerGetNotificationPreferences)
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.handlerUpdateNotificationPreferences)
+
+	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+
 	mux.HandleFunc("POST /api/login", func(wrt http.ResponseWriter, req *http.Request) {
 		decoder := json.NewDecoder(req.Body)
 		params := userParameters{}
 		if err := decoder.Decode(&params); err != nil {
-			fmt.Printf("Error decoding parameters: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
 
-		user, err := apiCfg.db.GetUserByEmail(req.Context(), params.Email)
-		if err != nil {
-			wrt.WriteHeader(401)
-			return
-		}
-		// Check to see if requested password matches stored hash
-		if err := auth.CheckPasswordHash(params.Password, user.HashedPassword); err != nil {
-			wrt.WriteHeader(401)
-			wrt.Write([]byte("incorrect email or password"))
+		if !apiCfg.checkLoginAllowed(wrt, req, params.Email) {
 			return
 		}
 
-		// Create JWT
-		ss, err := auth.MakeJWT(user.ID, apiCfg.secret, time.Hour)
+		user, err := apiCfg.db.GetUserByEmail(req.Context(), params.Email)
 		if err != nil {
-			fmt.Printf("Error creating JWT: %s\n", err)
-			wrt.WriteHeader(500)
+			// Spend as long as a real check would, so timing doesn't reveal unknown emails
+			apiCfg.hashers.Verify(params.Password, apiCfg.dummyHash)
+			apiCfg.loginFailed(wrt, req, params.Email)
 			return
 		}
-
-		// Create refresh token
-		rt, err := auth.MakeRefreshToken()
+		// Check to see if requested password matches stored hash
+		needsRehash, err := apiCfg.verifyPassword(params.Password, user.HashedPassword)
 		if err != nil {
-			fmt.Printf("Error creating refresh token: %s\n", err)
-			wrt.WriteHeader(500)
-			return
+			apiCfg.loginFailed(wrt, req, params.Email)
+			return
+		}
+		// Upgrade hashes made with an old algorithm or cost while we have the plaintext
+		if needsRehash {
+			if hashedPassword, err := apiCfg.hashers.Hash(params.Password); err != nil {
+				slog.ErrorContext(req.Context(), "Error rehashing password", "error", err)
+			} else if err := apiCfg.db.UpdateUserPassword(req.Context(), database.UpdateUserPasswordParams{
+				ID:             user.ID,
+				HashedPassword: hashedPassword,
+			}); err != nil {
+				slog.ErrorContext(req.Context(), "Error updating rehashed password for user", "user_id", user.ID, "error", err)
+			}
 		}
 
-		// Save refresh token to DB
-		apiCfg.db.CreateRefreshToken(req.Context(), database.CreateRefreshTokenParams{
-			Token: rt,
-			UserID: uuid.NullUUID{
-				UUID:  user.ID,
-				Valid: true},
-			ExpiresAt: time.Now().Add(24 * 60 * time.Hour),
-		})
-
-		dat, err := json.Marshal(User{
-			ID:           user.ID,
-			CreatedAt:    user.CreatedAt,
-			UpdatedAt:    user.UpdatedAt,
-			Email:        user.Email,
-			Token:        ss,
-			RefreshToken: rt,
-		})
-		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
-			wrt.WriteHeader(500)
-			return
-		}
-		wrt.WriteHeader(200)
-		wrt.Write(dat)
+		apiCfg.completeLogin(wrt, req, user)
 	})
 
-	mux.HandleFunc("POST /api/chirps", func(wrt http.ResponseWriter, req *http.Request) {
-		// Check JWT first
-		tokenString, err := auth.GetBearerToken(req.Header)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/users/totp", apiCfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/users/totp/confirm", apiCfg.handlerTOTPConfirm)
+	mux.HandleFunc("DELETE /api/users/totp", apiCfg.handlerTOTPDisable)
+
+	mux.HandleFunc("GET /api/auth/{provider}/login", apiCfg.handlerSSOLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", apiCfg.handlerSSOCallback)
+	mux.HandleFunc("POST /api/auth/{provider}/link", apiCfg.handlerSSOLink)
+	mux.HandleFunc("GET /api/users/identities", apiCfg.handlerListIdentities)
+
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerCreateOAuthClient)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthConsent)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+
+	mux.HandleFunc("POST /api/keys", apiCfg.handlerCreateAPIKey)
+	mux.HandleFunc("GET /api/keys", apiCfg.handlerListAPIKeys)
+	mux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.handlerRevokeAPIKey)
+
+	mux.HandleFunc("POST /api/webhooks", apiCfg.handlerCreateWebhook)
+	mux.HandleFunc("GET /api/webhooks", apiCfg.handlerListWebhooks)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", apiCfg.handlerDeleteWebhook)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", apiCfg.handlerListWebhookDeliveries)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries/{deliveryID}", apiCfg.handlerGetWebhookDelivery)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", apiCfg.handlerRedeliverWebhook)
+
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerPasswordResetConfirm)
+
+	mux.Handle("POST /api/chirps", apiCfg.middlewareAuth(scopeChirpsWrite, func(wrt http.ResponseWriter, req *http.Request) {
+		userId := principalFromContext(req.Context()).UserID
+
+		// Only verified accounts may post
+		user, err := apiCfg.db.GetUser(req.Context(), userId)
 		if err != nil {
 			wrt.WriteHeader(401)
 			return
 		}
-		userId, err := auth.ValidateJWT(tokenString, apiCfg.secret)
-		if err != nil {
-			wrt.WriteHeader(401)
+		if !user.EmailVerified {
+			respondWithError(wrt, 403, "Email address has not been verified")
 			return
 		}
 
 		decoder := json.NewDecoder(req.Body)
 		params := chirpParameters{}
 		if err := decoder.Decode(&params); err != nil {
-			fmt.Printf("Error decoding parameters: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
@@ -303,7 +472,7 @@ func main() {
 				Error: "Chirp is too long",
 			})
 			if err != nil {
-				fmt.Printf("Error marshalling JSON: %v\n", err)
+				slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
 				wrt.WriteHeader(500)
 			} else {
 				wrt.WriteHeader(400)
@@ -312,7 +481,15 @@ func main() {
 			return
 		}
 
-		chirp, err := apiCfg.db.CreateChirp(req.Context(), database.CreateChirpParams{
+		tx, err := apiCfg.dbConn.BeginTx(req.Context(), nil)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		defer tx.Rollback()
+		qtx := apiCfg.withTx(tx)
+		chirp, err := qtx.CreateChirp(req.Context(), database.CreateChirpParams{
 			Body: removeProfanities(params.Body),
 			UserID: uuid.NullUUID{
 				UUID:  userId,
@@ -320,10 +497,22 @@ func main() {
 			},
 		})
 		if err != nil {
-			fmt.Printf("Error creating chirp: %v\n", err)
+			slog.ErrorContext(req.Context(), "Error creating chirp", "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		if err := publishChirpEvent(req.Context(), qtx, chirpEventCreated, chirp); err != nil {
+			slog.ErrorContext(req.Context(), "Error publishing chirp event", "type", chirpEventCreated, "chirp_id", chirp.ID, "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
+		notifyMentions(req.Context(), qtx, chirp)
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(req.Context(), "Error committing chirp", "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		chirpsCreated.Inc()
 
 		message := Chirp{
 			ID:        chirp.ID,
@@ -334,20 +523,32 @@ func main() {
 		}
 		dat, err := json.Marshal(message)
 		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
 
 		wrt.WriteHeader(201)
 		wrt.Write(dat)
-	})
+	}))
 
 	mux.HandleFunc("GET /api/chirps", func(wrt http.ResponseWriter, req *http.Request) {
+		viewer, err := apiCfg.viewer(req)
+		if err != nil {
+			respondWithAuthError(wrt, err)
+			return
+		}
+
 		wrt.Header().Set("Content-Type", "application/json")
-		chirps, err := apiCfg.db.GetAllChirps(req.Context())
+		var chirps []database.Chirp
+		if viewer.Valid {
+			// Signed-in readers don't see authors they blocked or muted, or who blocked them
+			chirps, err = apiCfg.db.GetChirpsForViewer(req.Context(), viewer.UUID)
+		} else {
+			chirps, err = apiCfg.db.GetAllChirps(req.Context())
+		}
 		if err != nil {
-			fmt.Printf("Error getting all chirps from DB: %v\n", err)
+			slog.ErrorContext(req.Context(), "Error getting all chirps from DB", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
@@ -365,7 +566,7 @@ func main() {
 
 		dat, err := json.Marshal(messages)
 		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
@@ -375,10 +576,16 @@ func main() {
 	})
 
 	mux.HandleFunc("GET /api/chirps/{chirpID}", func(wrt http.ResponseWriter, req *http.Request) {
+		viewer, err := apiCfg.viewer(req)
+		if err != nil {
+			respondWithAuthError(wrt, err)
+			return
+		}
+
 		wrt.Header().Set("Content-Type", "application/json")
 		chirpID := req.PathValue("chirpID")
 		if chirpID == "" {
-			fmt.Println("failed to parse requested chirp ID")
+			slog.InfoContext(req.Context(), "failed to parse requested chirp ID")
 			wrt.WriteHeader(500)
 			return
 		}
@@ -390,7 +597,16 @@ func main() {
 			return
 		}
 		chirp, err := apiCfg.db.GetChirp(req.Context(), id)
-		if err != nil {
+		// Chirps that are hidden or by suspended users aren't public, and
+		// blocking hides chirps in both directions
+		if err == nil && !chirp.HiddenAt.Valid && chirp.UserID.Valid {
+			err = apiCfg.checkSuspension(req.Context(), chirp.UserID.UUID)
+		}
+		visible := false
+		if err == nil && !chirp.HiddenAt.Valid {
+			visible, err = apiCfg.canSee(req.Context(), viewer, chirp.UserID)
+		}
+		if err != nil || !visible {
 			wrt.WriteHeader(404)
 			fmt.Fprintf(wrt, "No chirp found for id %v", chirpID)
 			return
@@ -406,7 +622,7 @@ func main() {
 
 		dat, err := json.Marshal(message)
 		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+			slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
@@ -415,22 +631,13 @@ func main() {
 		wrt.Write(dat)
 	})
 
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", func(wrt http.ResponseWriter, req *http.Request) {
-		// Check JWT first
-		tokenString, err := auth.GetBearerToken(req.Header)
-		if err != nil {
-			wrt.WriteHeader(401)
-			return
-		}
-		userId, err := auth.ValidateJWT(tokenString, apiCfg.secret)
-		if err != nil {
-			wrt.WriteHeader(401)
-			return
-		}
+	mux.Handle("DELETE /api/chirps/{chirpID}", apiCfg.middlewareAuth(scopeChirpsWrite, func(wrt http.ResponseWriter, req *http.Request) {
+		userId := principalFromContext(req.Context()).UserID
+
 		// Return 403 if user ID not equal to chirp ID's user id
 		chirpID := req.PathValue("chirpID")
 		if chirpID == "" {
-			fmt.Println("failed to parse requested chirp ID")
+			slog.InfoContext(req.Context(), "failed to parse requested chirp ID")
 			wrt.WriteHeader(500)
 			return
 		}
@@ -451,66 +658,58 @@ func main() {
 			wrt.WriteHeader(403)
 			return
 		}
-		// Delete chirp
-		if err := apiCfg.db.DeleteChirp(req.Context(), chirp.ID); err != nil {
-			fmt.Println("Failed to delete chirp")
-			wrt.WriteHeader(500)
-			return
-		}
-		wrt.WriteHeader(204)
-	})
-
-	mux.HandleFunc("POST /api/refresh", func(wrt http.ResponseWriter, req *http.Request) {
-		// Check refresh token first
-		tokenString, err := auth.GetBearerToken(req.Header)
+		tx, err := apiCfg.dbConn.BeginTx(req.Context(), nil)
 		if err != nil {
-			fmt.Printf("error checking refresh token: %v\n", err)
-			wrt.WriteHeader(401)
-			return
-		}
-
-		// Get refresh token from DB
-		token, err := apiCfg.db.GetRefreshToken(req.Context(), tokenString)
-		if err != nil || token.ExpiresAt.Before(time.Now()) {
-			fmt.Printf("err because token did not exist or was expired: %v\n", err)
-			wrt.WriteHeader(401)
+			slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+			wrt.WriteHeader(500)
 			return
 		}
-
-		// Create new JWT for the given user
-		jwt, err := auth.MakeJWT(token.UserID.UUID, apiCfg.secret, time.Hour)
-		if err != nil {
-			fmt.Printf("Error making JWT: %s\n", err)
+		defer tx.Rollback()
+		qtx := apiCfg.withTx(tx)
+		// Deleted chirps can be restored until the grace period runs out
+		if err := qtx.SoftDeleteChirp(req.Context(), chirp.ID); err != nil {
+			slog.ErrorContext(req.Context(), "Failed to delete chirp", "chirp_id", chirp.ID, "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-		wrt.WriteHeader(200)
-		message := struct {
-			Token string `json:"token"`
-		}{
-			Token: jwt,
+		if !chirp.HiddenAt.Valid {
+			if err := publishChirpEvent(req.Context(), qtx, chirpEventDeleted, chirp); err != nil {
+				slog.ErrorContext(req.Context(), "Error publishing chirp event", "type", chirpEventDeleted, "chirp_id", chirp.ID, "error", err)
+				wrt.WriteHeader(500)
+				return
+			}
 		}
-		dat, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Error marshalling JSON: %s\n", err)
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(req.Context(), "Error committing chirp deletion", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
-		wrt.Write(dat)
-	})
+		wrt.WriteHeader(204)
+	}))
+
+	mux.HandleFunc("GET /api/chirps/stream", apiCfg.handlerChirpStream)
+	mux.HandleFunc("GET /api/ws", apiCfg.handlerWebSocket)
+	mux.Handle("POST /api/chirps/import", apiCfg.middlewareAuth(scopeChirpsWrite, apiCfg.handlerImportChirps))
+	mux.Handle("POST /api/chirps/{chirpID}/restore", apiCfg.middlewareAuth(scopeChirpsWrite, apiCfg.handlerRestoreChirp))
+	mux.Handle("POST /api/chirps/{chirpID}/report", apiCfg.middlewareAuth(scopeChirpsWrite, apiCfg.handlerReportChirp))
+	mux.HandleFunc("GET /api/moderation/reports", apiCfg.handlerListReports)
+	mux.HandleFunc("POST /api/moderation/reports/{reportID}/claim", apiCfg.handlerClaimReport)
+	mux.HandleFunc("POST /api/moderation/reports/{reportID}/resolve", apiCfg.handlerResolveReport)
+
+	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 
 	mux.HandleFunc("POST /api/revoke", func(wrt http.ResponseWriter, req *http.Request) {
 		// Check refresh token first
 		refreshToken, err := auth.GetBearerToken(req.Header)
 		if err != nil {
-			fmt.Printf("error getting refresh token: %v\n", err)
+			slog.InfoContext(req.Context(), "Missing or malformed refresh token", "error", err)
 			wrt.WriteHeader(401)
 			return
 		}
 
 		// Revoke token in DB
 		if err := apiCfg.db.RevokeRefreshToken(req.Context(), refreshToken); err != nil {
-			fmt.Printf("error revoking refresh token: %v\n", err)
+			slog.ErrorContext(req.Context(), "error revoking refresh token", "error", err)
 			wrt.WriteHeader(500)
 			return
 		}
@@ -518,13 +717,37 @@ func main() {
 	})
 
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:     ":8080",
+		Handler:  middlewareTracing(mux, middlewareLogging(mux, middlewareMetrics(mux, apiCfg.middlewareRateLimit(mux, defaultRateLimit, routeRateLimits)))),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
+	// Shutdown doesn't wait for streams to end on their own
+	server.RegisterOnShutdown(apiCfg.chirpHub.Close)
+
+	// On SIGINT or SIGTERM, report not-ready first so load balancers stop
+	// sending new traffic, then let in-flight requests finish
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+
+		slog.Info("Shutting down", "drain_delay", drainDelay)
+		apiCfg.shuttingDown.Store(true)
+		time.Sleep(drainDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down server", "error", err)
+		}
+	}()
 
 	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
-		fmt.Printf("Server failure.  Error: %v", err)
+		slog.Error("Server failure", "error", err)
 		os.Exit(1)
 	}
+	<-shutdownDone
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsRegistry holds everything served on /metrics.  The admin page reads
+// from it too, so both always agree.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_http_requests_total",
+		Help: "HTTP requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chirpy_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	httpRequestsInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "chirpy_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+	fileserverHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_fileserver_hits_total",
+		Help: "Requests for the static site under /app/.",
+	})
+	chirpsCreated = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_chirps_created_total",
+		Help: "Chirps posted or imported.",
+	})
+	chirpStreamSubscribers = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "chirpy_chirp_stream_subscribers",
+		Help: "Clients connected to the chirp event stream.",
+	})
+	chirpStreamDropped = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_chirp_stream_dropped_total",
+		Help: "Chirp stream clients disconnected for falling behind.",
+	})
+	websocketConnections = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "chirpy_websocket_connections",
+		Help: "Open WebSocket connections.",
+	})
+	websocketDisconnects = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_websocket_disconnects_total",
+		Help: "WebSocket connections closed, by reason.",
+	}, []string{"reason"})
+	websocketMessagesSent = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_websocket_messages_sent_total",
+		Help: "Messages sent to WebSocket clients.",
+	})
+	webhookDeliveries = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_webhook_delivery_attempts_total",
+		Help: "Webhook delivery attempts, by result: succeeded, retried, or failed for good.",
+	}, []string{"result"})
+	logins = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_logins_total",
+		Help: "Logins that started a session.",
+	})
+	loginFailures = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "chirpy_login_failures_total",
+		Help: "Login attempts with a wrong email, password or second factor.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// statusRecorder remembers the status code and body size a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// routePattern is the pattern of the route the mux would pick for a request,
+// with requests that match no route grouped together.
+func routePattern(mux *http.ServeMux, req *http.Request) string {
+	if _, pattern := mux.Handler(req); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// middlewareMetrics counts and times every request under the route pattern
+// the mux would pick for it, so path parameters don't blow up the number of
+// series.
+func middlewareMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	f := func(wrt http.ResponseWriter, req *http.Request) {
+		route := routePattern(mux, req)
+
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: wrt}
+		next.ServeHTTP(rec, req)
+		if rec.status == 0 {
+			rec.status = 200
+		}
+
+		code := strconv.Itoa(rec.status)
+		httpRequests.WithLabelValues(route, req.Method, code).Inc()
+		httpRequestDuration.WithLabelValues(route, req.Method, code).Observe(time.Since(start).Seconds())
+	}
+	return http.HandlerFunc(f)
+}
+
+func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
+	f := func(wrt http.ResponseWriter, req *http.Request) {
+		fileserverHits.Inc()
+		next.ServeHTTP(wrt, req)
+	}
+	return http.HandlerFunc(f)
+}
+
+func handlerPrometheusMetrics() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+var adminMetricsTemplate = template.Must(template.New("metrics").Parse(`<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited {{.Hits}} times!</p>
+<table>
+<tr><td>Requests served</td><td>{{.Requests}}</td></tr>
+<tr><td>Requests in flight</td><td>{{.InFlight}}</td></tr>
+<tr><td>Chirps created</td><td>{{.ChirpsCreated}}</td></tr>
+<tr><td>Logins</td><td>{{.Logins}}</td></tr>
+<tr><td>Failed logins</td><td>{{.LoginFailures}}</td></tr>
+<tr><td>Open database connections</td><td>{{.DBConnections}}</td></tr>
+</table>
+</body></html>`))
+
+type adminMetrics struct {
+	Hits          int64
+	Requests      int64
+	InFlight      int64
+	ChirpsCreated int64
+	Logins        int64
+	LoginFailures int64
+	DBConnections int64
+}
+
+// handlerAdminMetrics renders a summary of the metrics registry as HTML
+func (cfg *apiConfig) handlerAdminMetrics(wrt http.ResponseWriter, _ *http.Request) {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		slog.Error("Error gathering metrics", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	totals := map[string]int64{}
+	for _, family := range families {
+		totals[family.GetName()] = metricTotal(family)
+	}
+
+	wrt.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wrt.WriteHeader(200)
+	adminMetricsTemplate.Execute(wrt, adminMetrics{
+		Hits:          totals["chirpy_fileserver_hits_total"] - cfg.fileserverHitsReset.Load(),
+		Requests:      totals["chirpy_http_requests_total"],
+		InFlight:      totals["chirpy_http_requests_in_flight"],
+		ChirpsCreated: totals["chirpy_chirps_created_total"],
+		Logins:        totals["chirpy_logins_total"],
+		LoginFailures: totals["chirpy_login_failures_total"],
+		DBConnections: totals["go_sql_open_connections"],
+	})
+}
+
+// resetFileserverHits restarts the count on the admin page.  The Prometheus
+// counter itself only ever goes up.
+func (cfg *apiConfig) resetFileserverHits() {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		return
+	}
+	for _, family := range families {
+		if family.GetName() == "chirpy_fileserver_hits_total" {
+			cfg.fileserverHitsReset.Store(metricTotal(family))
+		}
+	}
+}
+
+// metricTotal adds up every series in a family, counting observations for
+// histograms
+func metricTotal(family *dto.MetricFamily) int64 {
+	var total float64
+	for _, m := range family.GetMetric() {
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			total += m.GetCounter().GetValue()
+		case dto.MetricType_GAUGE:
+			total += m.GetGauge().GetValue()
+		case dto.MetricType_HISTOGRAM:
+			total += float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	return int64(total)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareMetricsUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(404)
+	})
+	mux.HandleFunc("GET /api/healthz", func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.Write([]byte("OK\n"))
+	})
+	handler := middlewareMetrics(mux, mux)
+
+	chirps := httpRequests.WithLabelValues("GET /api/chirps/{chirpID}", "GET", "404")
+	healthz := httpRequests.WithLabelValues("GET /api/healthz", "GET", "200")
+	unmatched := httpRequests.WithLabelValues("unmatched", "GET", "404")
+	before := []float64{testutil.ToFloat64(chirps), testutil.ToFloat64(healthz), testutil.ToFloat64(unmatched)}
+
+	for _, path := range []string{"/api/chirps/1", "/api/chirps/2", "/api/healthz", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	after := []float64{testutil.ToFloat64(chirps), testutil.ToFloat64(healthz), testutil.ToFloat64(unmatched)}
+	want := []float64{2, 1, 1}
+	for i := range want {
+		if got := after[i] - before[i]; got != want[i] {
+			t.Errorf("series %d: got %v new requests, want %v", i, got, want[i])
+		}
+	}
+	if got := testutil.ToFloat64(httpRequestsInFlight); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+}
+
+func TestPrometheusMetricsEndpoint(t *testing.T) {
+	chirpsCreated.Inc()
+
+	rec := httptest.NewRecorder()
+	handlerPrometheusMetrics().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, name := range []string{"chirpy_chirps_created_total", "chirpy_http_requests_in_flight", "go_goroutines"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected %s in the exposition", name)
+		}
+	}
+}
+
+func TestAdminMetricsPageReset(t *testing.T) {
+	cfg := &apiConfig{}
+	cfg.resetFileserverHits()
+
+	app := cfg.middlewareMetricsInc(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for range 3 {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app/", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerAdminMetrics(rec, httptest.NewRequest("GET", "/admin/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "Chirpy has been visited 3 times!") {
+		t.Errorf("expected 3 visits on the admin page, got %s", body)
+	}
+
+	cfg.resetFileserverHits()
+	rec = httptest.NewRecorder()
+	cfg.handlerAdminMetrics(rec, httptest.NewRequest("GET", "/admin/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "Chirpy has been visited 0 times!") {
+		t.Errorf("expected the reset to restart the count, got %s", rec.Body.String())
+	}
+}
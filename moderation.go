@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// Values of chirp_reports.reason
+var reportReasons = []string{"spam", "harassment", "hate", "violence", "sexual", "misinformation", "other"}
+
+// Values of chirp_reports.resolution
+const (
+	resolutionDismiss = "dismiss"
+	resolutionHide    = "hide"
+	resolutionDelete  = "delete"
+	resolutionSuspend = "suspend"
+)
+
+const (
+	defaultReportThreshold = 3
+	maxReportDetails       = 1000
+	reportQueuePageSize    = 50
+)
+
+// loadReportThreshold reads CHIRP_REPORT_THRESHOLD, the number of unresolved
+// reports that hide a chirp until a moderator reviews it.  0 disables hiding.
+func loadReportThreshold() (int, error) {
+	threshold := os.Getenv("CHIRP_REPORT_THRESHOLD")
+	if threshold == "" {
+		return defaultReportThreshold, nil
+	}
+	n, err := strconv.Atoi(threshold)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid CHIRP_REPORT_THRESHOLD %q", threshold)
+	}
+	return n, nil
+}
+
+func chirpReportResponse(report database.ChirpReport) ChirpReport {
+	resp := ChirpReport{
+		ID:         report.ID,
+		CreatedAt:  report.CreatedAt,
+		ChirpID:    report.ChirpID,
+		ReporterID: report.ReporterID,
+		Reason:     report.Reason,
+		Details:    report.Details,
+		Status:     report.Status,
+		Resolution: report.Resolution.String,
+	}
+	if report.ClaimedBy.Valid {
+		resp.ClaimedBy = &report.ClaimedBy.UUID
+	}
+	if report.ClaimedAt.Valid {
+		resp.ClaimedAt = &report.ClaimedAt.Time
+	}
+	if report.ResolvedBy.Valid {
+		resp.ResolvedBy = &report.ResolvedBy.UUID
+	}
+	if report.ResolvedAt.Valid {
+		resp.ResolvedAt = &report.ResolvedAt.Time
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerReportChirp(wrt http.ResponseWriter, req *http.Request) {
+	userId := principalFromContext(req.Context()).UserID
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("chirpID")))
+		return
+	}
+	chirp, err := cfg.db.GetChirp(req.Context(), chirpID)
+	// Hidden chirps are already out of public view, and while hidden the only
+	// open reports are the ones that hid them, so dismissing those can safely
+	// unhide the chirp
+	if err != nil || chirp.HiddenAt.Valid {
+		respondWithError(wrt, 404, fmt.Sprintf("No chirp found for id %v", chirpID))
+		return
+	}
+	// Chirps hidden from the reporter by a block can't be reported
+	visible, err := cfg.canSee(req.Context(), uuid.NullUUID{UUID: userId, Valid: true}, chirp.UserID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error checking blocks for chirp", "chirp_id", chirp.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !visible {
+		respondWithError(wrt, 404, fmt.Sprintf("No chirp found for id %v", chirpID))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := reportParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !slices.Contains(reportReasons, params.Reason) {
+		respondWithError(wrt, 400, fmt.Sprintf("Unknown reason %q", params.Reason))
+		return
+	}
+	if len(params.Details) > maxReportDetails {
+		respondWithError(wrt, 400, "Details are too long")
+		return
+	}
+	if chirp.UserID.UUID == userId {
+		respondWithError(wrt, 400, "You cannot report your own chirp")
+		return
+	}
+
+	report, err := cfg.db.CreateChirpReport(req.Context(), database.CreateChirpReportParams{
+		ChirpID:    chirp.ID,
+		ReporterID: userId,
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 409, "You have already reported this chirp")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving report for chirp", "chirp_id", chirp.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Enough independent reports hide the chirp until a moderator looks at it
+	if cfg.reportThreshold > 0 {
+		count, err := cfg.db.CountUnresolvedChirpReports(req.Context(), chirp.ID)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error counting reports for chirp", "chirp_id", chirp.ID, "error", err)
+		} else if count >= int64(cfg.reportThreshold) {
+			if err := cfg.hideReportedChirp(req.Context(), chirp); err != nil {
+				slog.ErrorContext(req.Context(), "Error hiding chirp", "chirp_id", chirp.ID, "error", err)
+			}
+		}
+	}
+
+	respondWithJSON(wrt, 201, chirpReportResponse(report))
+}
+
+// hideReportedChirp hides a chirp pending review, announcing that it's gone
+// in the same transaction.
+func (cfg *apiConfig) hideReportedChirp(ctx context.Context, chirp database.Chirp) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+	if err := qtx.HideChirp(ctx, chirp.ID); err != nil {
+		return err
+	}
+	if err := publishChirpEvent(ctx, qtx, chirpEventDeleted, chirp); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) handlerListReports(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleModerator, roleAdmin); !ok {
+		return
+	}
+
+	status := req.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	if !slices.Contains([]string{"open", "claimed", "resolved"}, status) {
+		respondWithError(wrt, 400, fmt.Sprintf("Unknown status %q", status))
+		return
+	}
+	page := 1
+	if p := req.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			respondWithError(wrt, 400, fmt.Sprintf("Invalid page %q", p))
+			return
+		}
+		page = n
+	}
+
+	rows, err := cfg.db.ListChirpReports(req.Context(), database.ListChirpReportsParams{
+		Status: status,
+		Limit:  reportQueuePageSize,
+		Offset: int32((page - 1) * reportQueuePageSize),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing reports", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	entries := make([]reportQueueEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = reportQueueEntry{
+			ChirpReport: chirpReportResponse(database.ChirpReport{
+				ID:         row.ID,
+				CreatedAt:  row.CreatedAt,
+				ChirpID:    row.ChirpID,
+				ReporterID: row.ReporterID,
+				Reason:     row.Reason,
+				Details:    row.Details,
+				Status:     row.Status,
+				ClaimedBy:  row.ClaimedBy,
+				ClaimedAt:  row.ClaimedAt,
+				ResolvedBy: row.ResolvedBy,
+				ResolvedAt: row.ResolvedAt,
+				Resolution: row.Resolution,
+			}),
+			ChirpBody:    row.ChirpBody,
+			AuthorID:     row.AuthorID.UUID,
+			ChirpHidden:  row.ChirpHiddenAt.Valid,
+			ChirpDeleted: row.ChirpDeletedAt.Valid,
+		}
+	}
+	respondWithJSON(wrt, 200, entries)
+}
+
+func (cfg *apiConfig) handlerClaimReport(wrt http.ResponseWriter, req *http.Request) {
+	moderator, ok := cfg.authorize(wrt, req, roleModerator, roleAdmin)
+	if !ok {
+		return
+	}
+
+	reportID, err := uuid.Parse(req.PathValue("reportID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("reportID")))
+		return
+	}
+	report, err := cfg.db.ClaimChirpReport(req.Context(), database.ClaimChirpReportParams{
+		ID:        reportID,
+		ClaimedBy: uuid.NullUUID{UUID: moderator.ID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := cfg.db.GetChirpReport(req.Context(), reportID); err != nil {
+			respondWithError(wrt, 404, fmt.Sprintf("No report found for id %v", reportID))
+			return
+		}
+		respondWithError(wrt, 409, "Report has already been claimed or resolved")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error claiming report", "report_id", reportID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, chirpReportResponse(report))
+}
+
+// handlerResolveReport applies a moderator's decision to the reported chirp
+// and resolves every outstanding report against it.
+func (cfg *apiConfig) handlerResolveReport(wrt http.ResponseWriter, req *http.Request) {
+	moderator, ok := cfg.authorize(wrt, req, roleModerator, roleAdmin)
+	if !ok {
+		return
+	}
+
+	reportID, err := uuid.Parse(req.PathValue("reportID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("reportID")))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := resolveReportParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !slices.Contains([]string{resolutionDismiss, resolutionHide, resolutionDelete, resolutionSuspend}, params.Action) {
+		respondWithError(wrt, 400, fmt.Sprintf("Unknown action %q", params.Action))
+		return
+	}
+	if params.SuspendDays < 0 {
+		respondWithError(wrt, 400, "suspend_days cannot be negative")
+		return
+	}
+
+	report, err := cfg.db.GetChirpReport(req.Context(), reportID)
+	if err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No report found for id %v", reportID))
+		return
+	}
+	if report.Status == "resolved" {
+		respondWithError(wrt, 409, "Report has already been resolved")
+		return
+	}
+	if report.ClaimedBy.Valid && report.ClaimedBy.UUID != moderator.ID {
+		respondWithError(wrt, 409, "Report has been claimed by another moderator")
+		return
+	}
+	// The author deleting the chirp doesn't settle the reports, since they can
+	// restore it; hiding it keeps it down even then
+	chirp, err := cfg.db.GetChirpIncludingDeleted(req.Context(), report.ChirpID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting chirp", "chirp_id", report.ChirpID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	if _, err := qtx.ResolveChirpReports(req.Context(), database.ResolveChirpReportsParams{
+		ChirpID:    chirp.ID,
+		Resolution: sql.NullString{String: params.Action, Valid: true},
+		ResolvedBy: uuid.NullUUID{UUID: moderator.ID, Valid: true},
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error resolving reports for chirp", "chirp_id", chirp.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	switch params.Action {
+	case resolutionDismiss:
+		// Undo the automatic hiding while the reports were pending
+		err = qtx.UnhideChirp(req.Context(), chirp.ID)
+	case resolutionHide:
+		err = qtx.HideChirp(req.Context(), chirp.ID)
+	case resolutionDelete:
+		// Soft deleted, so the reports are kept until the chirp is purged.  It's
+		// hidden too, so the author restoring it doesn't bring it back.
+		if err = qtx.HideChirp(req.Context(), chirp.ID); err == nil {
+			err = qtx.SoftDeleteChirp(req.Context(), chirp.ID)
+		}
+	case resolutionSuspend:
+		if !chirp.UserID.Valid {
+			respondWithError(wrt, 400, "Chirp has no author to suspend")
+			return
+		}
+		author, err := qtx.GetUser(req.Context(), chirp.UserID.UUID)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error getting user", "user_id", chirp.UserID.UUID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		if author.Role != roleUser {
+			respondWithError(wrt, 403, "Moderators and admins cannot be suspended from the queue")
+			return
+		}
+		if err := qtx.HideChirp(req.Context(), chirp.ID); err != nil {
+			slog.ErrorContext(req.Context(), "Error hiding chirp", "chirp_id", chirp.ID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		if err := suspendUser(req.Context(), qtx, author.ID, moderator.ID, params.Reason, params.SuspendDays); err != nil {
+			slog.ErrorContext(req.Context(), "Error suspending user", "user_id", author.ID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error applying moderation action", "action", params.Action, "chirp_id", chirp.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	// Stream clients only hear about the chirp if its visibility changed, which
+	// it can't while the chirp is deleted
+	if hidden := params.Action != resolutionDismiss; hidden != chirp.HiddenAt.Valid && !chirp.DeletedAt.Valid {
+		eventType := chirpEventDeleted
+		if !hidden {
+			eventType = chirpEventCreated
+		}
+		if err := publishChirpEvent(req.Context(), qtx, eventType, chirp); err != nil {
+			slog.ErrorContext(req.Context(), "Error publishing chirp event", "type", eventType, "chirp_id", chirp.ID, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing report resolution", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	wrt.WriteHeader(204)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoadReportThreshold(t *testing.T) {
+	t.Setenv("CHIRP_REPORT_THRESHOLD", "")
+	if n, err := loadReportThreshold(); err != nil || n != defaultReportThreshold {
+		t.Errorf("expected default threshold %d, got %d (err %v)", defaultReportThreshold, n, err)
+	}
+
+	t.Setenv("CHIRP_REPORT_THRESHOLD", "0")
+	if n, err := loadReportThreshold(); err != nil || n != 0 {
+		t.Errorf("expected hiding to be disabled, got %d (err %v)", n, err)
+	}
+
+	for _, invalid := range []string{"-1", "three"} {
+		t.Setenv("CHIRP_REPORT_THRESHOLD", invalid)
+		if _, err := loadReportThreshold(); err == nil {
+			t.Errorf("expected error for CHIRP_REPORT_THRESHOLD=%q", invalid)
+		}
+	}
+}
+
+// reportRow is a chirp_reports row for an open report
+func reportRow(id, chirpID, reporterID uuid.UUID) []driver.Value {
+	return []driver.Value{id.String(), time.Now(), chirpID.String(), reporterID.String(), "spam", "", "open", nil, nil, nil, nil, nil}
+}
+
+func TestHandlerReportChirpThreshold(t *testing.T) {
+	chirpID := uuid.New()
+	reporterID := uuid.New()
+	for _, c := range []struct {
+		name     string
+		hiddenAt any
+		reports  int64
+		want     int
+		hidden   bool
+	}{
+		{"below threshold", nil, 2, 201, false},
+		{"at threshold", nil, 3, 201, true},
+		{"already hidden", time.Now(), 3, 404, false},
+	} {
+		db := newFakeDB(map[string][]driver.Value{
+			"GetUser":                     userRow(reporterID, "saul@bettercall.com", true, roleUser),
+			"GetChirp":                    chirpRow(chirpID, uuid.New(), c.hiddenAt),
+			"IsBlockedEitherWay":          {false},
+			"CreateChirpReport":           reportRow(uuid.New(), chirpID, uuid.New()),
+			"CountUnresolvedChirpReports": {c.reports},
+			"HideChirp":                   nil,
+		})
+		cfg := db.apiConfig()
+		cfg.reportThreshold = 3
+		req := requestAs(t, cfg, reporterID, "POST", "/api/chirps/"+chirpID.String()+"/report", `{"reason": "spam"}`)
+		rec := serve("POST /api/chirps/{chirpID}/report", cfg.middlewareAuth(scopeChirpsWrite, cfg.handlerReportChirp), req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d %s", c.name, c.want, rec.Code, rec.Body.String())
+		}
+		if db.called("HideChirp") != c.hidden {
+			t.Errorf("%s: expected hidden %v", c.name, c.hidden)
+		}
+		if c.hiddenAt != nil && db.called("CreateChirpReport") {
+			t.Errorf("%s: expected no report to be saved", c.name)
+		}
+	}
+}
+
+func TestHandlerResolveReportDeleteKeepsReports(t *testing.T) {
+	moderatorID := uuid.New()
+	chirpID := uuid.New()
+	reportID := uuid.New()
+	db := newFakeDB(map[string][]driver.Value{
+		"GetUser":                  userRow(moderatorID, "mod@chirpy.dev", true, roleModerator),
+		"GetChirpReport":           reportRow(reportID, chirpID, uuid.New()),
+		"GetChirpIncludingDeleted": chirpRow(chirpID, uuid.New(), nil),
+		"ResolveChirpReports":      nil,
+		"HideChirp":                nil,
+		"SoftDeleteChirp":          nil,
+	})
+	cfg := db.apiConfig()
+
+	req := requestAs(t, cfg, moderatorID, "POST", "/api/moderation/reports/"+reportID.String()+"/resolve", `{"action": "delete"}`)
+	rec := serve("POST /api/moderation/reports/{reportID}/resolve", http.HandlerFunc(cfg.handlerResolveReport), req)
+	if rec.Code != 204 {
+		t.Fatalf("expected 204, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !db.called("SoftDeleteChirp") || !db.called("HideChirp") || db.called("DeleteChirp") {
+		t.Errorf("expected the chirp to be hidden and soft deleted, not deleted outright")
+	}
+}
+
+func TestHandlerResolveReportOnDeletedChirp(t *testing.T) {
+	moderatorID := uuid.New()
+	chirpID := uuid.New()
+	reportID := uuid.New()
+	deleted := chirpRow(chirpID, uuid.New(), nil)
+	deleted[6] = time.Now()
+	db := newFakeDB(map[string][]driver.Value{
+		"GetUser":                  userRow(moderatorID, "mod@chirpy.dev", true, roleModerator),
+		"GetChirpReport":           reportRow(reportID, chirpID, uuid.New()),
+		"GetChirpIncludingDeleted": deleted,
+		"ResolveChirpReports":      nil,
+		"HideChirp":                nil,
+	})
+	cfg := db.apiConfig()
+
+	// Hidden, so the author restoring it doesn't bring it back
+	req := requestAs(t, cfg, moderatorID, "POST", "/api/moderation/reports/"+reportID.String()+"/resolve", `{"action": "hide"}`)
+	rec := serve("POST /api/moderation/reports/{reportID}/resolve", http.HandlerFunc(cfg.handlerResolveReport), req)
+	if rec.Code != 204 {
+		t.Fatalf("expected 204, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !db.called("HideChirp") || !db.called("ResolveChirpReports") {
+		t.Error("expected the chirp to be hidden and its reports resolved")
+	}
+	if db.called("NotifyChirpEvent") {
+		t.Error("expected no event for a chirp that was already gone")
+	}
+}
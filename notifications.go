@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	notificationFollow  = "follow"
+	notificationLike    = "like"
+	notificationReply   = "reply"
+	notificationMention = "mention"
+	notificationRechirp = "rechirp"
+
+	notificationsPageSize = 50
+)
+
+var notificationTypes = []string{
+	notificationFollow,
+	notificationLike,
+	notificationReply,
+	notificationMention,
+	notificationRechirp,
+}
+
+// notificationGroupKey decides which notifications are shown together: all
+// new followers, and every like or rechirp of the same chirp.  A reply or
+// mention is its own chirp, so each is shown on its own.
+func notificationGroupKey(notificationType string, chirpID uuid.NullUUID) string {
+	if !chirpID.Valid {
+		return notificationType
+	}
+	return notificationType + ":" + chirpID.UUID.String()
+}
+
+// notify tells userID that actorID followed them, or liked, replied to,
+// mentioned them in or rechirped chirpID.  Run in a transaction, the
+// notification is only kept if the action commits.  Failures are logged
+// rather than failing the action itself.
+func notify(ctx context.Context, q *database.Queries, notificationType string, userID, actorID uuid.UUID, chirpID uuid.NullUUID) {
+	err := q.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:   userID,
+		ActorID:  actorID,
+		Type:     notificationType,
+		ChirpID:  chirpID,
+		GroupKey: notificationGroupKey(notificationType, chirpID),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating notification", "type", notificationType, "user_id", userID, "error", err)
+	}
+}
+
+// notifyMentions tells the users a new chirp mentions about it.  Addresses
+// that aren't anyone's are skipped.  Like notify, run it in the chirp's
+// transaction; failures are logged rather than failing the chirp.
+func notifyMentions(ctx context.Context, q *database.Queries, chirp database.Chirp) {
+	chirpID := uuid.NullUUID{UUID: chirp.ID, Valid: true}
+	for _, email := range mentions(chirp.Body) {
+		user, err := q.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error looking up mentioned user", "chirp_id", chirp.ID, "error", err)
+			continue
+		}
+		notify(ctx, q, notificationMention, user.ID, chirp.UserID.UUID, chirpID)
+	}
+}
+
+// notificationTypeFilter reads the optional ?type= filter, writing the error
+// response itself if it's invalid.
+func notificationTypeFilter(wrt http.ResponseWriter, req *http.Request) (sql.NullString, bool) {
+	t := req.URL.Query().Get("type")
+	if t == "" {
+		return sql.NullString{}, true
+	}
+	if !slices.Contains(notificationTypes, t) {
+		respondWithError(wrt, 400, fmt.Sprintf("Unknown notification type %q", t))
+		return sql.NullString{}, false
+	}
+	return sql.NullString{String: t, Valid: true}, true
+}
+
+func (cfg *apiConfig) handlerListNotifications(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	notificationType, ok := notificationTypeFilter(wrt, req)
+	if !ok {
+		return
+	}
+	page := 1
+	if p := req.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			respondWithError(wrt, 400, fmt.Sprintf("Invalid page %q", p))
+			return
+		}
+		page = n
+	}
+
+	rows, err := cfg.db.ListNotificationGroups(req.Context(), database.ListNotificationGroupsParams{
+		UserID: userId,
+		Type:   notificationType,
+		Limit:  notificationsPageSize,
+		Offset: int32((page - 1) * notificationsPageSize),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing notifications", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	groups := make([]NotificationGroup, len(rows))
+	for i, row := range rows {
+		groups[i] = NotificationGroup{
+			ID:          row.ID,
+			CreatedAt:   row.CreatedAt,
+			Type:        row.Type,
+			ActorID:     row.ActorID,
+			OtherActors: row.GroupSize - 1,
+			Unread:      row.UnreadCount > 0,
+		}
+		if row.ChirpID.Valid {
+			groups[i].ChirpID = &row.ChirpID.UUID
+		}
+	}
+	respondWithJSON(wrt, 200, groups)
+}
+
+// handlerUnreadNotifications counts unread inbox entries, so a group of
+// notifications counts once
+func (cfg *apiConfig) handlerUnreadNotifications(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	count, err := cfg.db.CountUnreadNotificationGroups(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error counting unread notifications", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, unreadNotificationCount{UnreadCount: count})
+}
+
+// handlerMarkNotificationRead marks read the whole group a notification is
+// shown in
+func (cfg *apiConfig) handlerMarkNotificationRead(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	notificationID, err := uuid.Parse(req.PathValue("notificationID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("notificationID")))
+		return
+	}
+
+	notification, err := cfg.db.GetNotification(req.Context(), database.GetNotificationParams{
+		ID:     notificationID,
+		UserID: userId,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 404, fmt.Sprintf("No notification found for id %v", notificationID))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting notification", "notification_id", notificationID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := cfg.db.MarkNotificationGroupRead(req.Context(), database.MarkNotificationGroupReadParams{
+		UserID:   userId,
+		GroupKey: notification.GroupKey,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error marking notification read", "notification_id", notificationID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// handlerMarkAllNotificationsRead empties the inbox, or with ?type= just the
+// notifications of that type
+func (cfg *apiConfig) handlerMarkAllNotificationsRead(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	notificationType, ok := notificationTypeFilter(wrt, req)
+	if !ok {
+		return
+	}
+	if err := cfg.db.MarkAllNotificationsRead(req.Context(), database.MarkAllNotificationsReadParams{
+		UserID: userId,
+		Type:   notificationType,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error marking notifications read", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// notificationPreferences maps every notification type to whether the user
+// receives it
+func (cfg *apiConfig) notificationPreferences(ctx context.Context, userID uuid.UUID) (map[string]bool, error) {
+	rows, err := cfg.db.ListNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs := make(map[string]bool, len(notificationTypes))
+	for _, t := range notificationTypes {
+		prefs[t] = true
+	}
+	for _, row := range rows {
+		prefs[row.Type] = row.Enabled
+	}
+	return prefs, nil
+}
+
+func (cfg *apiConfig) handlerGetNotificationPreferences(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	prefs, err := cfg.notificationPreferences(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing notification preferences", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, prefs)
+}
+
+// handlerUpdateNotificationPreferences turns notification types on or off.
+// Types left out of the request keep their current setting.
+func (cfg *apiConfig) handlerUpdateNotificationPreferences(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := map[string]bool{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	for t := range params {
+		if !slices.Contains(notificationTypes, t) {
+			respondWithError(wrt, 400, fmt.Sprintf("Unknown notification type %q", t))
+			return
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+	for t, enabled := range params {
+		if err := qtx.SetNotificationPreference(req.Context(), database.SetNotificationPreferenceParams{
+			UserID:  userId,
+			Type:    t,
+			Enabled: enabled,
+		}); err != nil {
+			slog.ErrorContext(req.Context(), "Error saving notification preference", "user_id", userId, "type", t, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	prefs, err := cfg.notificationPreferences(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing notification preferences", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, prefs)
+}
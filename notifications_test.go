@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+func TestNotificationGroupKey(t *testing.T) {
+	chirpA := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	chirpB := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+
+	if notificationGroupKey(notificationLike, chirpA) != notificationGroupKey(notificationLike, chirpA) {
+		t.Error("expected likes of the same chirp to be grouped")
+	}
+	if notificationGroupKey(notificationLike, chirpA) == notificationGroupKey(notificationLike, chirpB) {
+		t.Error("expected likes of different chirps to be kept apart")
+	}
+	if notificationGroupKey(notificationLike, chirpA) == notificationGroupKey(notificationRechirp, chirpA) {
+		t.Error("expected likes and rechirps of a chirp to be kept apart")
+	}
+	if notificationGroupKey(notificationFollow, uuid.NullUUID{}) != notificationFollow {
+		t.Error("expected all follows to be grouped")
+	}
+}
+
+func TestNotifyMentions(t *testing.T) {
+	chirp := database.Chirp{
+		ID:     uuid.New(),
+		Body:   "No mentions here",
+		UserID: uuid.NullUUID{UUID: uuid.New(), Valid: true},
+	}
+	db := newFakeDB(map[string][]driver.Value{
+		"GetUserByEmail":     userRow(uuid.New(), "kim@wexlermcgill.com", true, roleUser),
+		"CreateNotification": nil,
+	})
+	notifyMentions(t.Context(), db.queries(), chirp)
+	if db.called("GetUserByEmail") || db.called("CreateNotification") {
+		t.Error("expected no one to be notified of a chirp without mentions")
+	}
+
+	chirp.Body = "Call @kim@wexlermcgill.com"
+	notifyMentions(t.Context(), db.queries(), chirp)
+	if !db.called("CreateNotification") {
+		t.Error("expected the mentioned user to be notified")
+	}
+
+	// An address that isn't anyone's
+	db = newFakeDB(map[string][]driver.Value{"CreateNotification": nil})
+	notifyMentions(t.Context(), db.queries(), chirp)
+	if !db.called("GetUserByEmail") || db.called("CreateNotification") {
+		t.Error("expected an unknown address to be skipped")
+	}
+}
+
+func TestHandlerListNotifications(t *testing.T) {
+	cfg := &apiConfig{secret: "secret", db: newActiveUserQueries()}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerListNotifications(rec, requestAs(t, cfg, uuid.New(), "GET", "/api/notifications?type=like&page=2", ""))
+	if rec.Code != 200 || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("expected an empty inbox, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	for _, query := range []string{"type=poke", "page=0", "page=two"} {
+		rec := httptest.NewRecorder()
+		cfg.handlerListNotifications(rec, requestAs(t, cfg, uuid.New(), "GET", "/api/notifications?"+query, ""))
+		if rec.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.handlerListNotifications(rec, httptest.NewRequest("GET", "/api/notifications", nil))
+	if rec.Code != 401 {
+		t.Errorf("expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+func TestHandlerMarkNotificationRead(t *testing.T) {
+	cfg := &apiConfig{secret: "secret", db: newActiveUserQueries()}
+
+	pattern, handler := "POST /api/notifications/{notificationID}/read", http.HandlerFunc(cfg.handlerMarkNotificationRead)
+
+	rec := serve(pattern, handler, requestAs(t, cfg, uuid.New(), "POST", "/api/notifications/"+uuid.NewString()+"/read", ""))
+	if rec.Code != 404 {
+		t.Errorf("expected 404 for someone else's or a missing notification, got %d", rec.Code)
+	}
+	rec = serve(pattern, handler, requestAs(t, cfg, uuid.New(), "POST", "/api/notifications/saul/read", ""))
+	if rec.Code != 400 {
+		t.Errorf("expected 400 for a bad id, got %d", rec.Code)
+	}
+}
+
+func TestHandlerNotificationPreferences(t *testing.T) {
+	cfg := &apiConfig{secret: "secret", db: newActiveUserQueries()}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerGetNotificationPreferences(rec, requestAs(t, cfg, uuid.New(), "GET", "/api/notifications/preferences", ""))
+	prefs := map[string]bool{}
+	if err := json.NewDecoder(rec.Body).Decode(&prefs); err != nil {
+		t.Fatalf("error decoding preferences: %v", err)
+	}
+	for _, notificationType := range notificationTypes {
+		if !prefs[notificationType] {
+			t.Errorf("expected %s notifications to be on by default", notificationType)
+		}
+	}
+	if len(prefs) != len(notificationTypes) {
+		t.Errorf("expected one preference per type, got %v", prefs)
+	}
+
+	rec = httptest.NewRecorder()
+	cfg.handlerUpdateNotificationPreferences(rec, requestAs(t, cfg, uuid.New(), "PUT", "/api/notifications/preferences", `{"like": false, "poke": false}`))
+	if rec.Code != 400 {
+		t.Errorf("expected 400 for an unknown type, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	oauthCodeTTL         = 5 * time.Minute
+	oauthAccessTokenTTL  = 15 * time.Minute
+	oauthRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// authenticateOAuthToken accepts an access token issued to a third-party client
+// through the token endpoint, as long as it hasn't been revoked.
+func (cfg *apiConfig) authenticateOAuthToken(ctx context.Context, tokenString string) (principal, error) {
+	claims, err := auth.ValidateOAuthAccessToken(tokenString, cfg.secret)
+	if err != nil {
+		return principal{}, err
+	}
+	revoked, err := cfg.db.IsOAuthAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return principal{}, err
+	}
+	if revoked {
+		return principal{}, errors.New("token has been revoked")
+	}
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return principal{}, err
+	}
+	return principal{
+		UserID:   userId,
+		ClientID: claims.ClientID,
+		Scopes:   claims.Scopes(),
+	}, nil
+}
+
+// validRedirectURI accepts absolute https URLs, and http only for loopback
+// addresses used by native apps and local development.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	host := u.Hostname()
+	return u.Scheme == "https" || (u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1"))
+}
+
+func parseScopes(scope string) ([]string, bool) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return []string{scopeChirpsRead}, true
+	}
+	for _, s := range scopes {
+		if !slices.Contains(grantableScopes, s) {
+			return nil, false
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(scopes))), true
+}
+
+func (cfg *apiConfig) handlerCreateOAuthClient(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := oauthClientParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(wrt, 400, "No name was supplied")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(wrt, 400, "No redirect URIs were supplied")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if !validRedirectURI(uri) {
+			respondWithError(wrt, 400, fmt.Sprintf("Invalid redirect URI %s", uri))
+			return
+		}
+	}
+
+	clientID, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating client ID", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	clientID = "chirpy-" + clientID[:24]
+
+	var secret string
+	var secretHash sql.NullString
+	if !params.Public {
+		if secret, err = auth.MakeRefreshToken(); err != nil {
+			slog.ErrorContext(req.Context(), "Error creating client secret", "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.db.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerID:      userId,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving OAuth client", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// The secret is only ever returned here
+	respondWithJSON(wrt, 201, OAuthClient{
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		CreatedAt:    client.CreatedAt,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Public:       !client.SecretHash.Valid,
+	})
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+<head><title>Authorize {{.ClientName}} - Chirpy</title></head>
+<body>
+<h1>Authorize {{.ClientName}}</h1>
+<p><strong>{{.ClientName}}</strong> would like to access your Chirpy account and:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+<p><label>Email <input type="email" name="email" required></label></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<p><label>Two-factor code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+type consentPage struct {
+	ClientName    string
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+	Error         string
+}
+
+// authorizeRequest validates the parameters shared by GET and POST
+// /oauth/authorize.  Problems with the client or redirect URI are shown to the
+// user, since redirecting to an unverified URI would make Chirpy an open
+// redirector; other problems are reported to the client via the redirect.
+func (cfg *apiConfig) authorizeRequest(wrt http.ResponseWriter, req *http.Request, form url.Values) (consentPage, bool) {
+	client, err := cfg.db.GetOAuthClient(req.Context(), form.Get("client_id"))
+	if err != nil {
+		http.Error(wrt, "Unknown client", 400)
+		return consentPage{}, false
+	}
+	redirectURI := form.Get("redirect_uri")
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		http.Error(wrt, "Redirect URI is not registered for this client", 400)
+		return consentPage{}, false
+	}
+
+	page := consentPage{
+		ClientName:    client.Name,
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		State:         form.Get("state"),
+		CodeChallenge: form.Get("code_challenge"),
+	}
+	if form.Get("response_type") != "code" {
+		redirectWithOAuthError(wrt, req, page, "unsupported_response_type", "Only the authorization code flow is supported")
+		return consentPage{}, false
+	}
+	if page.CodeChallenge == "" || form.Get("code_challenge_method") != "S256" {
+		redirectWithOAuthError(wrt, req, page, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return consentPage{}, false
+	}
+	scopes, ok := parseScopes(form.Get("scope"))
+	if !ok {
+		redirectWithOAuthError(wrt, req, page, "invalid_scope", "Unknown scope requested")
+		return consentPage{}, false
+	}
+	page.Scopes = scopes
+	page.Scope = strings.Join(scopes, " ")
+	return page, true
+}
+
+func redirectWithOAuthError(wrt http.ResponseWriter, req *http.Request, page consentPage, code, description string) {
+	query := url.Values{}
+	query.Set("error", code)
+	query.Set("error_description", description)
+	if page.State != "" {
+		query.Set("state", page.State)
+	}
+	http.Redirect(wrt, req, addQuery(page.RedirectURI, query), http.StatusFound)
+}
+
+func addQuery(rawURL string, query url.Values) string {
+	u, _ := url.Parse(rawURL)
+	q := u.Query()
+	for key, vals := range query {
+		q[key] = vals
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func renderConsent(wrt http.ResponseWriter, code int, page consentPage) {
+	wrt.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Stop the consent screen being framed by another site and clicked through
+	wrt.Header().Set("X-Frame-Options", "DENY")
+	wrt.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	wrt.WriteHeader(code)
+	if err := consentTemplate.Execute(wrt, page); err != nil {
+		slog.Error("Error rendering consent page", "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(wrt http.ResponseWriter, req *http.Request) {
+	page, ok := cfg.authorizeRequest(wrt, req, req.URL.Query())
+	if !ok {
+		return
+	}
+	renderConsent(wrt, 200, page)
+}
+
+// handlerOAuthConsent handles the consent form, which signs the user in and
+// records their decision in one step since Chirpy has no browser sessions.
+func (cfg *apiConfig) handlerOAuthConsent(wrt http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(wrt, "Invalid form", 400)
+		return
+	}
+	page, ok := cfg.authorizeRequest(wrt, req, req.PostForm)
+	if !ok {
+		return
+	}
+	if req.PostForm.Get("decision") != "approve" {
+		redirectWithOAuthError(wrt, req, page, "access_denied", "The user denied the request")
+		return
+	}
+
+	email := req.PostForm.Get("email")
+	if _, ok := cfg.loginWait(req, email); !ok {
+		page.Error = "Too many failed login attempts, try again later"
+		renderConsent(wrt, 429, page)
+		return
+	}
+	user, ok := cfg.checkCredentials(req, email, req.PostForm.Get("password"))
+	if !ok {
+		page.Error = "Incorrect email or password"
+		renderConsent(wrt, 401, page)
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(req.Context(), "Error getting TOTP enrollment for user", "user_id", user.ID, "error", err)
+		http.Error(wrt, "Internal error", 500)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		if !cfg.checkSecondFactor(req, totp, totpParameters{Code: req.PostForm.Get("code")}) {
+			cfg.recordLoginFailure(req, email)
+			page.Error = "Invalid two-factor code"
+			renderConsent(wrt, 401, page)
+			return
+		}
+	}
+	cfg.accountLockouts.Success(accountLockoutKey(email))
+	if err := cfg.checkSuspension(req.Context(), user.ID); err != nil {
+		page.Error = "Your account is suspended"
+		renderConsent(wrt, 403, page)
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating authorization code", "error", err)
+		http.Error(wrt, "Internal error", 500)
+		return
+	}
+	err = cfg.db.CreateOAuthAuthorizationCode(req.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      page.ClientID,
+		UserID:        user.ID,
+		RedirectUri:   page.RedirectURI,
+		Scopes:        page.Scopes,
+		CodeChallenge: page.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving authorization code", "error", err)
+		http.Error(wrt, "Internal error", 500)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("code", code)
+	if page.State != "" {
+		query.Set("state", page.State)
+	}
+	http.Redirect(wrt, req, addQuery(page.RedirectURI, query), http.StatusFound)
+}
+
+func respondWithOAuthError(wrt http.ResponseWriter, code int, errCode, description string) {
+	wrt.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(wrt, code, oauthError{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+var errInvalidClient = errors.New("invalid client credentials")
+
+// authenticateClient identifies the calling client from HTTP Basic auth or the
+// client_id and client_secret form fields.  Public clients only send client_id.
+func (cfg *apiConfig) authenticateClient(req *http.Request) (database.OauthClient, error) {
+	clientID, secret, basic := req.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.db.GetOAuthClient(req.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errInvalidClient
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if client.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errInvalidClient
+		}
+	}
+	return client, nil
+}
+
+func (cfg *apiConfig) handlerOAuthToken(wrt http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(wrt, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, err := cfg.authenticateClient(req)
+	if errors.Is(err, errInvalidClient) {
+		respondWithOAuthError(wrt, 401, "invalid_client", "Client authentication failed")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error authenticating OAuth client", "error", err)
+		respondWithOAuthError(wrt, 500, "server_error", "")
+		return
+	}
+
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.grantAuthorizationCode(wrt, req, client)
+	case "refresh_token":
+		cfg.grantRefreshToken(wrt, req, client)
+	default:
+		respondWithOAuthError(wrt, 400, "unsupported_grant_type", "Supported grant types are authorization_code and refresh_token")
+	}
+}
+
+func (cfg *apiConfig) grantAuthorizationCode(wrt http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	code, err := cfg.db.UseOAuthAuthorizationCode(req.Context(), auth.HashToken(req.PostForm.Get("code")))
+	if err != nil ||
+		code.ClientID != client.ID ||
+		code.RedirectUri != req.PostForm.Get("redirect_uri") ||
+		!auth.VerifyPKCE(req.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(wrt, 400, "invalid_grant", "Authorization code is invalid, expired or already used")
+		return
+	}
+	cfg.issueOAuthTokens(wrt, req, client.ID, code.UserID, code.Scopes)
+}
+
+// grantRefreshToken rotates refresh tokens: each one can be used once, and the
+// client may narrow, but not widen, the scopes of the new tokens.
+func (cfg *apiConfig) grantRefreshToken(wrt http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	tokenHash := auth.HashToken(req.PostForm.Get("refresh_token"))
+	token, err := cfg.db.GetOAuthRefreshToken(req.Context(), tokenHash)
+	if err != nil || token.ClientID != client.ID || token.RevokedAt.Valid || token.ExpiresAt.Before(time.Now()) {
+		respondWithOAuthError(wrt, 400, "invalid_grant", "Refresh token is invalid, expired or revoked")
+		return
+	}
+
+	scopes := token.Scopes
+	if scope := req.PostForm.Get("scope"); scope != "" {
+		requested, ok := parseScopes(scope)
+		if !ok {
+			respondWithOAuthError(wrt, 400, "invalid_scope", "Unknown scope requested")
+			return
+		}
+		for _, s := range requested {
+			if !slices.Contains(token.Scopes, s) {
+				respondWithOAuthError(wrt, 400, "invalid_scope", "Requested scope exceeds the original grant")
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	rows, err := cfg.db.RevokeOAuthRefreshToken(req.Context(), tokenHash)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error revoking OAuth refresh token", "error", err)
+		respondWithOAuthError(wrt, 500, "server_error", "")
+		return
+	}
+	if rows == 0 {
+		// Lost a race with another refresh using the same token
+		respondWithOAuthError(wrt, 400, "invalid_grant", "Refresh token is invalid, expired or revoked")
+		return
+	}
+	cfg.issueOAuthTokens(wrt, req, client.ID, token.UserID, scopes)
+}
+
+func (cfg *apiConfig) issueOAuthTokens(wrt http.ResponseWriter, req *http.Request, clientID string, userID uuid.UUID, scopes []string) {
+	if _, err := cfg.db.GetUser(req.Context(), userID); err != nil {
+		respondWithOAuthError(wrt, 400, "invalid_grant", "The user's account has been deleted")
+		return
+	}
+	if err := cfg.checkSuspension(req.Context(), userID); err != nil {
+		respondWithOAuthError(wrt, 400, "invalid_grant", "The user's account is suspended")
+		return
+	}
+	accessToken, err := auth.MakeOAuthAccessToken(userID, clientID, scopes, cfg.secret, oauthAccessTokenTTL)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating OAuth access token", "error", err)
+		respondWithOAuthError(wrt, 500, "server_error", "")
+		return
+	}
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating OAuth refresh token", "error", err)
+		respondWithOAuthError(wrt, 500, "server_error", "")
+		return
+	}
+	err = cfg.db.CreateOAuthRefreshToken(req.Context(), database.CreateOAuthRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(oauthRefreshTokenTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving OAuth refresh token", "error", err)
+		respondWithOAuthError(wrt, 500, "server_error", "")
+		return
+	}
+
+	wrt.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(wrt, 200, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// handlerOAuthIntrospect implements RFC 7662.  Clients may only introspect
+// their own tokens; anything else is reported as inactive.
+func (cfg *apiConfig) handlerOAuthIntrospect(wrt http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(wrt, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, err := cfg.authenticateClient(req)
+	if err != nil {
+		respondWithOAuthError(wrt, 401, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	wrt.Header().Set("Cache-Control", "no-store")
+	tokenString := req.PostForm.Get("token")
+	if claims, err := auth.ValidateOAuthAccessToken(tokenString, cfg.secret); err == nil && claims.ClientID == client.ID {
+		revoked, err := cfg.db.IsOAuthAccessTokenRevoked(req.Context(), claims.ID)
+		if err == nil && !revoked {
+			respondWithJSON(wrt, 200, oauthIntrospection{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientID:  claims.ClientID,
+				Subject:   claims.Subject,
+				TokenType: "Bearer",
+				ExpiresAt: claims.ExpiresAt.Unix(),
+				IssuedAt:  claims.IssuedAt.Unix(),
+			})
+			return
+		}
+	}
+
+	token, err := cfg.db.GetOAuthRefreshToken(req.Context(), auth.HashToken(tokenString))
+	if err == nil && token.ClientID == client.ID && !token.RevokedAt.Valid && token.ExpiresAt.After(time.Now()) {
+		respondWithJSON(wrt, 200, oauthIntrospection{
+			Active:    true,
+			Scope:     strings.Join(token.Scopes, " "),
+			ClientID:  token.ClientID,
+			Subject:   token.UserID.String(),
+			TokenType: "refresh_token",
+			ExpiresAt: token.ExpiresAt.Unix(),
+			IssuedAt:  token.CreatedAt.Unix(),
+		})
+		return
+	}
+
+	respondWithJSON(wrt, 200, oauthIntrospection{Active: false})
+}
+
+// handlerOAuthRevoke implements RFC 7009.  It responds 200 whether or not the
+// token was valid, so clients can't probe for tokens.
+func (cfg *apiConfig) handlerOAuthRevoke(wrt http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		respondWithOAuthError(wrt, 400, "invalid_request", "Invalid form")
+		return
+	}
+	client, err := cfg.authenticateClient(req)
+	if err != nil {
+		respondWithOAuthError(wrt, 401, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	tokenString := req.PostForm.Get("token")
+	if claims, err := auth.ValidateOAuthAccessToken(tokenString, cfg.secret); err == nil && claims.ClientID == client.ID {
+		if err := cfg.db.RevokeOAuthAccessToken(req.Context(), database.RevokeOAuthAccessTokenParams{
+			Jti:       claims.ID,
+			ExpiresAt: claims.ExpiresAt.Time,
+		}); err != nil {
+			slog.ErrorContext(req.Context(), "Error revoking OAuth access token", "error", err)
+			respondWithOAuthError(wrt, 503, "temporarily_unavailable", "")
+			return
+		}
+	} else if token, err := cfg.db.GetOAuthRefreshToken(req.Context(), auth.HashToken(tokenString)); err == nil && token.ClientID == client.ID {
+		if _, err := cfg.db.RevokeOAuthRefreshToken(req.Context(), token.TokenHash); err != nil {
+			slog.ErrorContext(req.Context(), "Error revoking OAuth refresh token", "error", err)
+			respondWithOAuthError(wrt, 503, "temporarily_unavailable", "")
+			return
+		}
+	}
+	wrt.WriteHeader(200)
+}
+
+// pruneOAuthGrants periodically deletes expired codes, refresh tokens and revocations.
+func (cfg *apiConfig) pruneOAuthGrants(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cfg.db.DeleteExpiredOAuthGrants(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error deleting expired OAuth grants", "error", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !auth.VerifyPKCE(verifier, challenge) {
+		t.Errorf("expected verifier to match challenge")
+	}
+	if auth.VerifyPKCE("wrong-verifier", challenge) {
+		t.Errorf("expected wrong verifier to be rejected")
+	}
+}
+
+func TestOAuthAccessToken(t *testing.T) {
+	userID := uuid.New()
+	token, err := auth.MakeOAuthAccessToken(userID, "chirpy-client", []string{scopeChirpsRead}, "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("error making OAuth access token: %v", err)
+	}
+
+	claims, err := auth.ValidateOAuthAccessToken(token, "secret")
+	if err != nil {
+		t.Fatalf("error validating OAuth access token: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.ClientID != "chirpy-client" || claims.ID == "" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if scopes := claims.Scopes(); len(scopes) != 1 || scopes[0] != scopeChirpsRead {
+		t.Errorf("expected scopes [%s], got %v", scopeChirpsRead, scopes)
+	}
+
+	if _, err := auth.ValidateJWT(token, "secret"); err == nil {
+		t.Errorf("an OAuth access token should not be accepted as a first-party access token")
+	}
+	session, err := auth.MakeJWT(userID, "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("error making JWT: %v", err)
+	}
+	if _, err := auth.ValidateOAuthAccessToken(session, "secret"); err == nil {
+		t.Errorf("a first-party access token should not be accepted as an OAuth access token")
+	}
+}
+
+func TestOAuthPrincipalScopes(t *testing.T) {
+	p := principal{UserID: uuid.New(), ClientID: "chirpy-client", Scopes: []string{scopeChirpsRead}}
+	if p.hasScope(scopeChirpsWrite) {
+		t.Errorf("OAuth principal should only hold its granted scopes")
+	}
+	if !p.hasScope(scopeChirpsRead) {
+		t.Errorf("OAuth principal should hold %s", scopeChirpsRead)
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	cases := map[string]bool{
+		"https://app.example.com/callback":   true,
+		"http://localhost:3000/callback":     true,
+		"http://127.0.0.1/callback":          true,
+		"http://app.example.com/callback":    false,
+		"https://app.example.com/cb#section": false,
+		"/callback":                          false,
+		"javascript:alert(1)":                false,
+	}
+	for uri, want := range cases {
+		if got := validRedirectURI(uri); got != want {
+			t.Errorf("validRedirectURI(%q) = %v, want %v", uri, got, want)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, ok := parseScopes("")
+	if !ok || len(scopes) != 1 || scopes[0] != scopeChirpsRead {
+		t.Errorf("expected default scope %s, got %v", scopeChirpsRead, scopes)
+	}
+	scopes, ok = parseScopes("chirps:write chirps:read chirps:write")
+	if !ok || len(scopes) != 2 {
+		t.Errorf("expected two deduplicated scopes, got %v", scopes)
+	}
+	if _, ok := parseScopes("chirps:read admin"); ok {
+		t.Errorf("expected unknown scope to be rejected")
+	}
+}
+
+func TestAddQuery(t *testing.T) {
+	query := url.Values{}
+	query.Set("code", "abc")
+	got := addQuery("https://app.example.com/cb?existing=1", query)
+	u, _ := url.Parse(got)
+	if u.Query().Get("existing") != "1" || u.Query().Get("code") != "abc" {
+		t.Errorf("unexpected redirect %s", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nfongster/chirpy/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loadPasswordHashers picks the algorithm for new password hashes from the environment:
+//
+//	PASSWORD_HASH       argon2id (default) or bcrypt
+//	ARGON2_MEMORY       argon2id memory in KiB (default 19456)
+//	ARGON2_ITERATIONS   argon2id passes (default 2)
+//	ARGON2_PARALLELISM  argon2id lanes (default 1)
+//	BCRYPT_COST         bcrypt cost (default 10)
+//
+// Hashes made with the other algorithm, or with different parameters, are still
+// accepted and get replaced the next time their owner logs in.
+func loadPasswordHashers() (*auth.Hashers, error) {
+	argon := auth.NewArgon2idHasher()
+	if err := envUint32("ARGON2_MEMORY", &argon.Memory); err != nil {
+		return nil, err
+	}
+	if err := envUint32("ARGON2_ITERATIONS", &argon.Iterations); err != nil {
+		return nil, err
+	}
+	parallelism := uint32(argon.Parallelism)
+	if err := envUint32("ARGON2_PARALLELISM", &parallelism); err != nil {
+		return nil, err
+	}
+	if parallelism > 255 {
+		return nil, fmt.Errorf("invalid ARGON2_PARALLELISM %d", parallelism)
+	}
+	argon.Parallelism = uint8(parallelism)
+
+	cost := uint32(bcrypt.DefaultCost)
+	if err := envUint32("BCRYPT_COST", &cost); err != nil {
+		return nil, err
+	}
+	if int(cost) < bcrypt.MinCost || int(cost) > bcrypt.MaxCost {
+		return nil, fmt.Errorf("invalid BCRYPT_COST %d", cost)
+	}
+	bc := auth.NewBcryptHasher(int(cost))
+
+	switch algorithm := os.Getenv("PASSWORD_HASH"); algorithm {
+	case "", "argon2id":
+		return auth.NewHashers(argon, bc), nil
+	case "bcrypt":
+		return auth.NewHashers(bc, argon), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASH %q", algorithm)
+	}
+}
+
+func envUint32(key string, dst *uint32) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+	n, err := strconv.ParseUint(val, 10, 32)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid %s %q", key, val)
+	}
+	*dst = uint32(n)
+	return nil
+}
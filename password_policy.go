@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+// loadPasswordPolicy builds the password policy from the environment:
+//
+//	PASSWORD_MIN_LENGTH  minimum length in characters (default 8)
+//	PASSWORD_REQUIRE     comma-separated classes from lower,upper,digit,symbol (default lower,upper,digit)
+//	PASSWORD_BLOCKLIST   file of extra common/breached passwords, one per line
+func loadPasswordPolicy() (*auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy()
+
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		n, err := strconv.Atoi(minLength)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", minLength)
+		}
+		policy.MinLength = n
+	}
+
+	if require, ok := os.LookupEnv("PASSWORD_REQUIRE"); ok {
+		policy.RequireLower, policy.RequireUpper, policy.RequireDigit, policy.RequireSymbol = false, false, false, false
+		for _, class := range strings.Split(require, ",") {
+			switch strings.TrimSpace(class) {
+			case "lower":
+				policy.RequireLower = true
+			case "upper":
+				policy.RequireUpper = true
+			case "digit":
+				policy.RequireDigit = true
+			case "symbol":
+				policy.RequireSymbol = true
+			case "":
+			default:
+				return nil, fmt.Errorf("unknown character class %q in PASSWORD_REQUIRE", class)
+			}
+		}
+	}
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening password blocklist: %w", err)
+		}
+		defer f.Close()
+		extra, err := auth.LoadPasswordBlocklist(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading password blocklist: %w", err)
+		}
+		for password := range extra {
+			policy.Blocklist[password] = struct{}{}
+		}
+	}
+
+	return policy, nil
+}
+
+// checkPasswordPolicy writes a 400 listing every violation and returns false if
+// the password is not acceptable for the given email.
+func (cfg *apiConfig) checkPasswordPolicy(wrt http.ResponseWriter, password, email string) bool {
+	violations := cfg.passwordPolicy.Check(password, email)
+	if violations == nil {
+		return true
+	}
+	respondWithJSON(wrt, 400, passwordPolicyError{
+		Error:      "Password does not meet the password policy",
+		Violations: violations,
+	})
+	return false
+}
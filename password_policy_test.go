@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+func violationCodes(violations []auth.PolicyViolation) []string {
+	codes := make([]string, len(violations))
+	for i, v := range violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicyAccepts(t *testing.T) {
+	policy := auth.DefaultPasswordPolicy()
+
+	if violations := policy.Check("Kettleman2008", "saul@bettercall.com"); violations != nil {
+		t.Errorf("Expected password to be accepted, got %v", violationCodes(violations))
+	}
+}
+
+func TestPasswordPolicyViolations(t *testing.T) {
+	policy := auth.DefaultPasswordPolicy()
+
+	cases := map[string]string{
+		"Ab1":                     "too_short",
+		"kettleman2008":           "missing_upper",
+		"KETTLEMAN2008":           "missing_lower",
+		"KettlemanBrothers":       "missing_digit",
+		"Saul@bettercall.com1":    "contains_email",
+		"xXsaulGoodman99":         "contains_email",
+		"Password123":             "breached",
+		strings.Repeat("Ab1", 30): "too_long",
+	}
+	for password, code := range cases {
+		codes := violationCodes(policy.Check(password, "saul@bettercall.com"))
+		if !strings.Contains(strings.Join(codes, ","), code) {
+			t.Errorf("Expected %s for \"%s\", got %v", code, password, codes)
+		}
+	}
+}
+
+func TestPasswordPolicySymbolRequirement(t *testing.T) {
+	policy := auth.DefaultPasswordPolicy()
+	policy.RequireSymbol = true
+
+	codes := violationCodes(policy.Check("Kettleman2008", ""))
+	if len(codes) != 1 || codes[0] != "missing_symbol" {
+		t.Errorf("Expected only missing_symbol, got %v", codes)
+	}
+	if violations := policy.Check("Kettleman-2008", ""); violations != nil {
+		t.Errorf("Expected password with symbol to be accepted, got %v", violationCodes(violations))
+	}
+}
+
+func TestLoadPasswordBlocklist(t *testing.T) {
+	blocklist, err := auth.LoadPasswordBlocklist(strings.NewReader("# comment\n\nHunter2\n  letmein  \n"))
+	if err != nil {
+		t.Errorf("error loading blocklist: %v", err)
+	}
+	for _, password := range []string{"hunter2", "letmein"} {
+		if _, ok := blocklist[password]; !ok {
+			t.Errorf("Expected \"%s\" in blocklist", password)
+		}
+	}
+	if len(blocklist) != 2 {
+		t.Errorf("Expected 2 entries, got %d", len(blocklist))
+	}
+}
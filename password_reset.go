@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/mailer"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+func (cfg *apiConfig) handlerPasswordResetRequest(wrt http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	params := passwordResetRequestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Look up the account and send the email in the background, so neither the
+	// response body nor its timing reveals whether the address is registered
+	ctx := context.WithoutCancel(req.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := cfg.sendPasswordResetEmail(ctx, params.Email); err != nil {
+			slog.ErrorContext(ctx, "Error sending password reset email", "error", err)
+		}
+	}()
+
+	wrt.WriteHeader(202)
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, email string) error {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		// Unknown addresses are silently ignored
+		return nil
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return fmt.Errorf("error creating password reset token: %w", err)
+	}
+	_, err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("error saving password reset token: %w", err)
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\nTo choose a new password, send this token to POST /api/password-reset/confirm:\n\n%s\n\nThe token expires in %v.  If you didn't ask for a reset you can ignore this email.\n", token, passwordResetTokenTTL)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    body,
+	})
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(wrt http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	params := passwordResetConfirmParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.Token == "" {
+		respondWithError(wrt, 400, "No token was supplied")
+		return
+	}
+	if params.Password == "" {
+		respondWithError(wrt, 400, "No password was supplied")
+		return
+	}
+
+	// Consume the token, set the password and sign out every session atomically
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	token, err := qtx.UsePasswordResetToken(req.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(wrt, 400, "Token is invalid, expired or already used")
+		return
+	}
+	// Rolling back on a policy violation leaves the token usable for another attempt
+	user, err := qtx.GetUser(req.Context(), token.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 410, "The account has been deleted")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting user", "user_id", token.UserID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !cfg.checkPasswordPolicy(wrt, params.Password, user.Email) {
+		return
+	}
+	hashedPassword, err := cfg.hashers.Hash(params.Password)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error hashing password", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := qtx.UpdateUserPassword(req.Context(), database.UpdateUserPasswordParams{
+		ID:             token.UserID,
+		HashedPassword: hashedPassword,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error updating password for user", "user_id", token.UserID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := qtx.InvalidatePasswordResetTokensForUser(req.Context(), token.UserID); err != nil {
+		slog.ErrorContext(req.Context(), "Error invalidating reset tokens for user", "user_id", token.UserID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := qtx.RevokeAllRefreshTokensForUser(req.Context(), uuid.NullUUID{
+		UUID:  token.UserID,
+		Valid: true,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error revoking refresh tokens for user", "user_id", token.UserID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing password reset", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	wrt.WriteHeader(204)
+}
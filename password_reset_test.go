@@ -0,0 +1,31 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+func TestPasswordResetConfirmDeletedUser(t *testing.T) {
+	const token = "reset-token"
+	db := newFakeDB(map[string][]driver.Value{
+		// GetUser finds nothing once the account has been deleted
+		"UsePasswordResetToken": {auth.HashToken(token), time.Now(), uuid.New().String(), time.Now().Add(time.Hour), time.Now()},
+	})
+	cfg := db.apiConfig()
+
+	rec := httptest.NewRecorder()
+	body := `{"token": "` + token + `", "password": "correct horse battery staple"}`
+	cfg.handlerPasswordResetConfirm(rec, httptest.NewRequest("POST", "/api/password-reset/confirm", strings.NewReader(body)))
+	if rec.Code != 410 {
+		t.Errorf("expected 410 for a deleted account, got %d", rec.Code)
+	}
+	if db.called("UpdateUserPassword") {
+		t.Error("expected a deleted account's password to be left alone")
+	}
+}
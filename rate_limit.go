@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/ratelimit"
+)
+
+var defaultRateLimit = ratelimit.Policy{
+	Name:   "default",
+	Limit:  300,
+	Period: time.Minute,
+}
+
+// routeRateLimits are stricter policies for routes that are expensive or
+// attractive to abuse, keyed by the route pattern they are registered under.
+var routeRateLimits = map[string]ratelimit.Policy{
+	"POST /api/chirps": {
+		Name:   "chirps-create",
+		Limit:  10,
+		Period: time.Minute,
+	},
+	"POST /api/chirps/import": {
+		Name:   "chirps-import",
+		Limit:  10,
+		Period: time.Hour,
+	},
+	"POST /api/chirps/{chirpID}/report": {
+		Name:   "chirps-report",
+		Limit:  20,
+		Period: time.Hour,
+	},
+	"POST /api/login": {
+		Name:   "login",
+		Limit:  10,
+		Period: time.Minute,
+	},
+	"POST /api/login/mfa": {
+		Name:   "login",
+		Limit:  10,
+		Period: time.Minute,
+	},
+	"POST /oauth/authorize": {
+		Name:   "login",
+		Limit:  10,
+		Period: time.Minute,
+	},
+	"POST /api/users/restore": {
+		Name:   "login",
+		Limit:  10,
+		Period: time.Minute,
+	},
+	"POST /api/users/export": {
+		Name:   "data-export",
+		Limit:  3,
+		Period: 24 * time.Hour,
+	},
+	"POST /api/password-reset/request": {
+		Name:   "password-reset",
+		Limit:  5,
+		Period: time.Hour,
+	},
+}
+
+// clientIP returns the address of the client, looking through X-Forwarded-For
+// only when the request came from a trusted proxy.
+func (cfg *apiConfig) clientIP(req *http.Request) string {
+	return ratelimit.ClientIP(req, cfg.trustedProxies)
+}
+
+// apiKeyLookupRateLimit caps how often one client can make the limiter look
+// up API keys it hasn't seen, so made-up keys can't be used to hammer the
+// database.
+var apiKeyLookupRateLimit = ratelimit.Policy{
+	Name:   "apikey-lookup",
+	Limit:  30,
+	Period: time.Minute,
+}
+
+// rateLimitIdentity keys limits by API key or by user when the request carries
+// a valid API key or access token, and by client IP otherwise.  Keys are
+// looked up rather than trusted, or made-up keys would each get a fresh bucket.
+func (cfg *apiConfig) rateLimitIdentity(req *http.Request) string {
+	if key, err := auth.GetAPIKey(req.Header); err == nil {
+		return cfg.apiKeyRateLimitIdentity(req, auth.HashToken(key))
+	}
+	if tokenString, err := auth.GetBearerToken(req.Header); err == nil {
+		if userId, err := auth.ValidateJWT(tokenString, cfg.secret); err == nil {
+			return "user:" + userId.String()
+		}
+	}
+	return "ip:" + cfg.clientIP(req)
+}
+
+// apiKeyRateLimitIdentity resolves an API key's hash to its key, from the
+// cache if it was seen recently.  A key that isn't cached is only looked up
+// while the client has lookups to spare, and is limited by IP otherwise.
+func (cfg *apiConfig) apiKeyRateLimitIdentity(req *http.Request, keyHash string) string {
+	if identity, ok := cfg.apiKeyIdentities.Get(keyHash); ok {
+		return identity
+	}
+	ip := cfg.clientIP(req)
+	result, err := cfg.rateLimiter.Take(req.Context(), apiKeyLookupRateLimit.Name+":ip:"+ip, apiKeyLookupRateLimit)
+	if err != nil || !result.Allowed {
+		return "ip:" + ip
+	}
+	apiKey, err := cfg.db.GetAPIKeyByHash(req.Context(), keyHash)
+	if err != nil {
+		return "ip:" + ip
+	}
+	identity := "apikey:" + apiKey.ID.String()
+	cfg.apiKeyIdentities.Put(keyHash, identity)
+	return identity
+}
+
+// middlewareRateLimit applies the policy for the route the mux would pick for
+// the request, falling back to defaultPolicy.
+func (cfg *apiConfig) middlewareRateLimit(mux *http.ServeMux, defaultPolicy ratelimit.Policy, routePolicies map[string]ratelimit.Policy) http.Handler {
+	f := func(wrt http.ResponseWriter, req *http.Request) {
+		policy := defaultPolicy
+		if _, pattern := mux.Handler(req); pattern != "" {
+			if routePolicy, ok := routePolicies[pattern]; ok {
+				policy = routePolicy
+			}
+		}
+
+		key := policy.Name + ":" + cfg.rateLimitIdentity(req)
+		result, err := cfg.rateLimiter.Take(req.Context(), key, policy)
+		if err != nil {
+			// Fail open, so a rate limit backend outage doesn't take the API down with it
+			slog.ErrorContext(req.Context(), "Error checking rate limit", "key", key, "error", err)
+			mux.ServeHTTP(wrt, req)
+			return
+		}
+
+		header := wrt.Header()
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds())))
+		header.Set("RateLimit-Limit", fmt.Sprint(result.Limit))
+		header.Set("RateLimit-Remaining", fmt.Sprint(result.Remaining))
+		header.Set("RateLimit-Reset", fmt.Sprint(int(math.Ceil(result.Reset.Seconds()))))
+		if !result.Allowed {
+			header.Set("Retry-After", fmt.Sprint(int(math.Ceil(result.RetryAfter.Seconds()))))
+			respondWithError(wrt, 429, "Rate limit exceeded")
+			return
+		}
+		mux.ServeHTTP(wrt, req)
+	}
+	return http.HandlerFunc(f)
+}
+
+// pruneRateLimits periodically discards idle buckets from stores that support it.
+func (cfg *apiConfig) pruneRateLimits(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg.apiKeyIdentities.Prune()
+		switch store := cfg.rateLimiter.(type) {
+		case *ratelimit.MemoryStore:
+			store.Prune(time.Hour)
+		case *ratelimit.PostgresStore:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := store.Prune(ctx, time.Hour); err != nil {
+				slog.ErrorContext(ctx, "Error pruning rate limit buckets", "error", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/ratelimit"
+)
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	now := time.Unix(1755361192, 0)
+	store := ratelimit.NewMemoryStore()
+	store.Now = func() time.Time { return now }
+	policy := ratelimit.Policy{Name: "test", Limit: 2, Period: 2 * time.Second}
+
+	for i := range 2 {
+		result, _ := store.Take(context.Background(), "k", policy)
+		if !result.Allowed || result.Remaining != 1-i {
+			t.Errorf("take %d: unexpected result %+v", i+1, result)
+		}
+	}
+	result, _ := store.Take(context.Background(), "k", policy)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("expected third take to be refused for 1s, got %+v", result)
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := store.Take(context.Background(), "k", policy); !result.Allowed {
+		t.Errorf("expected a token to have refilled, got %+v", result)
+	}
+	if result, _ := store.Take(context.Background(), "other", policy); !result.Allowed {
+		t.Errorf("expected separate keys to have separate buckets")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ratelimit.ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Errorf("error parsing trusted proxies: %v", err)
+	}
+
+	cases := []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"203.0.113.7:5000", "", "203.0.113.7"},
+		// Untrusted peers can't claim to be someone else
+		{"203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"10.1.2.3:5000", "198.51.100.1", "198.51.100.1"},
+		// Spoofed entries to the left of the real client are ignored
+		{"10.1.2.3:5000", "1.1.1.1, 198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"10.1.2.3:5000", "", "10.1.2.3"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/chirps", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if ip := ratelimit.ClientIP(req, trusted); ip != c.expected {
+			t.Errorf("%s via %q: expected %s, got %s", c.remoteAddr, c.forwarded, c.expected, ip)
+		}
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	cfg := &apiConfig{
+		secret:      "my_secret",
+		rateLimiter: ratelimit.NewMemoryStore(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps", func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(201)
+	})
+	mux.HandleFunc("GET /api/chirps", func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(200)
+	})
+	handler := cfg.middlewareRateLimit(mux, ratelimit.Policy{Name: "default", Limit: 100, Period: time.Minute}, map[string]ratelimit.Policy{
+		"POST /api/chirps": {Name: "strict", Limit: 1, Period: time.Minute},
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/chirps", nil))
+	if rec.Code != 201 || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected first response %d %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/chirps", nil))
+	if rec.Code != 429 || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps", nil))
+	if rec.Code != 200 || rec.Header().Get("RateLimit-Limit") != "100" {
+		t.Errorf("expected default policy on other routes, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRateLimitIdentityIgnoresUnknownAPIKeys(t *testing.T) {
+	cfg := &apiConfig{
+		db:               newEmptyQueries(),
+		rateLimiter:      ratelimit.NewMemoryStore(),
+		apiKeyIdentities: ratelimit.NewIdentityCache(time.Minute),
+	}
+	for _, key := range []string{"chirpy_aaaa1111_one", "chirpy_bbbb2222_two"} {
+		req := httptest.NewRequest("POST", "/api/login", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		req.Header.Set("Authorization", "ApiKey "+key)
+		if id := cfg.rateLimitIdentity(req); id != "ip:203.0.113.7" {
+			t.Errorf("expected a made-up key to be limited by IP, got %s", id)
+		}
+	}
+
+	keyID := uuid.New()
+	db := newFakeDB(map[string][]driver.Value{
+		"GetAPIKeyByHash": apiKeyRow(keyID, uuid.New(), "{chirps:read}"),
+	})
+	cfg.db = db.queries()
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	req.Header.Set("Authorization", "ApiKey chirpy_abcd1234_secret")
+	if id := cfg.rateLimitIdentity(req); id != "apikey:"+keyID.String() {
+		t.Errorf("expected a real key to be limited by key, got %s", id)
+	}
+
+	// Keys seen recently aren't looked up again
+	cfg.db = newEmptyQueries()
+	if id := cfg.rateLimitIdentity(req); id != "apikey:"+keyID.String() {
+		t.Errorf("expected a cached key to be limited by key, got %s", id)
+	}
+}
+
+func TestRateLimitIdentityCapsAPIKeyLookups(t *testing.T) {
+	db := newFakeDB(map[string][]driver.Value{
+		"GetAPIKeyByHash": apiKeyRow(uuid.New(), uuid.New(), "{chirps:read}"),
+	})
+	cfg := &apiConfig{
+		db:               db.queries(),
+		rateLimiter:      ratelimit.NewMemoryStore(),
+		apiKeyIdentities: ratelimit.NewIdentityCache(time.Minute),
+	}
+	// Every key resolves, but each new one costs the client a lookup
+	for i := range apiKeyLookupRateLimit.Limit + 1 {
+		req := httptest.NewRequest("GET", "/api/chirps", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		req.Header.Set("Authorization", fmt.Sprintf("ApiKey chirpy_abcd1234_%d", i))
+		id := cfg.rateLimitIdentity(req)
+		if lookedUp := id != "ip:203.0.113.7"; lookedUp != (i < apiKeyLookupRateLimit.Limit) {
+			t.Errorf("key %d: unexpected identity %s", i, id)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// viewer identifies who is reading chirps, so blocked and muted authors can
+// be filtered out.  Anonymous requests return a zero uuid.NullUUID;
+// credentials that are present but invalid, or lack the chirps:read scope,
+// are an error.
+func (cfg *apiConfig) viewer(req *http.Request) (uuid.NullUUID, error) {
+	if req.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}, nil
+	}
+	p, err := cfg.authenticatePrincipal(req)
+	if err == nil {
+		err = cfg.checkActive(req.Context(), p.UserID)
+	}
+	if err == nil && !p.hasScope(scopeChirpsRead) {
+		err = &missingScopeError{scope: scopeChirpsRead}
+	}
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: p.UserID, Valid: true}, nil
+}
+
+// canSee reports whether viewer may see content by author: neither may have
+// blocked the other.
+func (cfg *apiConfig) canSee(ctx context.Context, viewer uuid.NullUUID, author uuid.NullUUID) (bool, error) {
+	if !viewer.Valid || !author.Valid || viewer.UUID == author.UUID {
+		return true, nil
+	}
+	blocked, err := cfg.db.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+		UserA: viewer.UUID,
+		UserB: author.UUID,
+	})
+	return !blocked, err
+}
+
+// relationshipTarget authenticates the request and parses the {userID} it
+// acts on, writing the error response itself on failure.
+func (cfg *apiConfig) relationshipTarget(wrt http.ResponseWriter, req *http.Request) (userId, targetId uuid.UUID, ok bool) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	targetId, err = uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("userID")))
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	if targetId == userId {
+		respondWithError(wrt, 400, "You cannot block or mute yourself")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	if _, err := cfg.db.GetUser(req.Context(), targetId); err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No user found for id %v", targetId))
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	return userId, targetId, true
+}
+
+func (cfg *apiConfig) handlerBlockUser(wrt http.ResponseWriter, req *http.Request) {
+	userId, targetId, ok := cfg.relationshipTarget(wrt, req)
+	if !ok {
+		return
+	}
+	if err := cfg.db.BlockUser(req.Context(), database.BlockUserParams{
+		BlockerID: userId,
+		BlockedID: targetId,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error blocking user", "user_id", targetId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnblockUser(wrt http.ResponseWriter, req *http.Request) {
+	userId, targetId, ok := cfg.relationshipTarget(wrt, req)
+	if !ok {
+		return
+	}
+	rows, err := cfg.db.UnblockUser(req.Context(), database.UnblockUserParams{
+		BlockerID: userId,
+		BlockedID: targetId,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error unblocking user", "user_id", targetId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		respondWithError(wrt, 404, fmt.Sprintf("User %v is not blocked", targetId))
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerMuteUser(wrt http.ResponseWriter, req *http.Request) {
+	userId, targetId, ok := cfg.relationshipTarget(wrt, req)
+	if !ok {
+		return
+	}
+	if err := cfg.db.MuteUser(req.Context(), database.MuteUserParams{
+		MuterID: userId,
+		MutedID: targetId,
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error muting user", "user_id", targetId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnmuteUser(wrt http.ResponseWriter, req *http.Request) {
+	userId, targetId, ok := cfg.relationshipTarget(wrt, req)
+	if !ok {
+		return
+	}
+	rows, err := cfg.db.UnmuteUser(req.Context(), database.UnmuteUserParams{
+		MuterID: userId,
+		MutedID: targetId,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error unmuting user", "user_id", targetId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		respondWithError(wrt, 404, fmt.Sprintf("User %v is not muted", targetId))
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerListBlocks(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	blocks, err := cfg.db.ListBlockedUsers(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing blocked users", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	resp := make([]Relationship, len(blocks))
+	for i, block := range blocks {
+		resp[i] = Relationship{UserID: block.BlockedID, CreatedAt: block.CreatedAt}
+	}
+	respondWithJSON(wrt, 200, resp)
+}
+
+func (cfg *apiConfig) handlerListMutes(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	mutes, err := cfg.db.ListMutedUsers(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing muted users", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	resp := make([]Relationship, len(mutes))
+	for i, mute := range mutes {
+		resp[i] = Relationship{UserID: mute.MutedID, CreatedAt: mute.CreatedAt}
+	}
+	respondWithJSON(wrt, 200, resp)
+}
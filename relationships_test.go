@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestViewer(t *testing.T) {
+	id := uuid.New()
+	cfg := &apiConfig{secret: "my_secret", db: newFakeDB(map[string][]driver.Value{
+		"GetUser": userRow(id, "saul@bettercall.com", true, roleUser),
+	}).queries()}
+
+	viewer, err := cfg.viewer(httptest.NewRequest("GET", "/api/chirps", nil))
+	if err != nil || viewer.Valid {
+		t.Errorf("expected anonymous viewer, got %+v (err %v)", viewer, err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	if _, err := cfg.viewer(req); err == nil {
+		t.Errorf("expected invalid credentials to be rejected rather than treated as anonymous")
+	}
+
+	viewer, err = cfg.viewer(requestAs(t, cfg, id, "GET", "/api/chirps", ""))
+	if err != nil || !viewer.Valid || viewer.UUID != id {
+		t.Errorf("expected viewer %v, got %+v (err %v)", id, viewer, err)
+	}
+}
+
+func TestCanSeeWithoutRelationship(t *testing.T) {
+	cfg := &apiConfig{}
+	author := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+
+	// Anonymous readers and authors reading their own chirps never need a lookup
+	if ok, err := cfg.canSee(context.Background(), uuid.NullUUID{}, author); err != nil || !ok {
+		t.Errorf("expected anonymous viewer to see chirp, got %v (err %v)", ok, err)
+	}
+	if ok, err := cfg.canSee(context.Background(), author, author); err != nil || !ok {
+		t.Errorf("expected author to see their own chirp, got %v (err %v)", ok, err)
+	}
+}
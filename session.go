@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 24 * 60 * time.Hour
+)
+
+// Values of users.role
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// authenticate returns the ID of the user whose access token is in the request,
+// failing with a *suspendedError if they are suspended and sql.ErrNoRows if
+// their account has been deleted.
+func (cfg *apiConfig) authenticate(req *http.Request) (uuid.UUID, error) {
+	tokenString, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	userId, err := auth.ValidateJWT(tokenString, cfg.secret)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	// Suspending or deleting a user cuts off access tokens they already hold
+	if err := cfg.checkActive(req.Context(), userId); err != nil {
+		return uuid.UUID{}, err
+	}
+	setRequestUser(req.Context(), userId)
+	return userId, nil
+}
+
+// authorize authenticates the request and checks the user holds one of roles.
+// On failure it writes the 401 or 403 response itself.
+func (cfg *apiConfig) authorize(wrt http.ResponseWriter, req *http.Request, roles ...string) (database.User, bool) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		wrt.WriteHeader(401)
+		return database.User{}, false
+	}
+	if !slices.Contains(roles, user.Role) {
+		wrt.WriteHeader(403)
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// completeLogin finishes a login whose first factor has been checked: accounts
+// with two-factor auth get an MFA challenge, everyone else gets a session.
+// The account's failed attempts are only forgotten once the login is
+// complete, so a known password can't be used to keep resetting the count
+// while guessing codes.
+func (cfg *apiConfig) completeLogin(wrt http.ResponseWriter, req *http.Request, user database.User) {
+	if !cfg.checkNotSuspended(wrt, req, user.ID) {
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(req.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(req.Context(), "Error getting TOTP enrollment for user", "user_id", user.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		mfaToken, err := auth.MakeMFAChallengeToken(user.ID, cfg.secret, mfaChallengeTTL)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error creating MFA challenge token", "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+		respondWithJSON(wrt, 200, mfaChallenge{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	cfg.accountLockouts.Success(accountLockoutKey(user.Email))
+	session, err := cfg.newSession(req.Context(), user)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating session", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	logins.Inc()
+	respondWithJSON(wrt, 200, session)
+}
+
+// newSession issues an access token and a refresh token for a user who has
+// fully authenticated, and returns them with the user's profile.
+func (cfg *apiConfig) newSession(ctx context.Context, user database.User) (User, error) {
+	ss, err := auth.MakeJWT(user.ID, cfg.secret, accessTokenTTL)
+	if err != nil {
+		return User{}, fmt.Errorf("error creating JWT: %w", err)
+	}
+
+	rt, err := auth.MakeRefreshToken()
+	if err != nil {
+		return User{}, fmt.Errorf("error creating refresh token: %w", err)
+	}
+	_, err = cfg.db.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token: rt,
+		UserID: uuid.NullUUID{
+			UUID:  user.ID,
+			Valid: true},
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("error saving refresh token: %w", err)
+	}
+
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Token:         ss,
+		RefreshToken:  rt,
+	}, nil
+}
+
+// handlerRefresh exchanges a refresh token for a new access token.
+func (cfg *apiConfig) handlerRefresh(wrt http.ResponseWriter, req *http.Request) {
+	// Check refresh token first
+	tokenString, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		slog.InfoContext(req.Context(), "Missing or malformed refresh token", "error", err)
+		wrt.WriteHeader(401)
+		return
+	}
+
+	// Get refresh token from DB
+	token, err := cfg.db.GetRefreshToken(req.Context(), tokenString)
+	if err != nil || token.ExpiresAt.Before(time.Now()) {
+		slog.InfoContext(req.Context(), "Refresh token did not exist or was expired", "error", err)
+		wrt.WriteHeader(401)
+		return
+	}
+	if !cfg.checkNotSuspended(wrt, req, token.UserID.UUID) {
+		return
+	}
+
+	// Create new JWT for the given user
+	jwt, err := auth.MakeJWT(token.UserID.UUID, cfg.secret, time.Hour)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error making JWT", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(200)
+	message := struct {
+		Token string `json:"token"`
+	}{
+		Token: jwt,
+	}
+	dat, err := json.Marshal(message)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.Write(dat)
+}
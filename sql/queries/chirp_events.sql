@@ -0,0 +1,8 @@
+-- name: NotifyChirpEvent :exec
+-- Sent to every instance listening on chirp_events once the surrounding
+-- transaction commits.
+SELECT pg_notify('chirp_events', json_build_object(
+    'id', nextval('chirp_event_seq'),
+    'type', sqlc.arg(event_type)::text,
+    'chirp', sqlc.arg(chirp)::json
+)::text);
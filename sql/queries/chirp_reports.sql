@@ -0,0 +1,42 @@
+-- name: CreateChirpReport :one
+INSERT INTO chirp_reports (id, created_at, chirp_id, reporter_id, reason, details, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'open'
+)
+ON CONFLICT (chirp_id, reporter_id) DO NOTHING
+RETURNING *;
+
+-- name: CountUnresolvedChirpReports :one
+SELECT COUNT(*) FROM chirp_reports
+WHERE chirp_id = $1 AND status <> 'resolved';
+
+-- name: GetChirpReport :one
+SELECT * FROM chirp_reports
+WHERE id = $1;
+
+-- name: ListChirpReports :many
+-- Chirps their author has deleted stay in the queue, so deleting one can't
+-- dodge review.
+SELECT chirp_reports.*, chirps.body AS chirp_body, chirps.user_id AS author_id, chirps.hidden_at AS chirp_hidden_at, chirps.deleted_at AS chirp_deleted_at
+FROM chirp_reports
+JOIN chirps ON chirps.id = chirp_reports.chirp_id
+WHERE chirp_reports.status = $1
+ORDER BY chirp_reports.created_at ASC
+LIMIT $2 OFFSET $3;
+
+-- name: ClaimChirpReport :one
+UPDATE chirp_reports
+SET status = 'claimed', claimed_by = $2, claimed_at = NOW()
+WHERE id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: ResolveChirpReports :execrows
+UPDATE chirp_reports
+SET status = 'resolved', resolution = $2, resolved_by = $3, resolved_at = NOW()
+WHERE chirp_id = $1 AND status <> 'resolved';
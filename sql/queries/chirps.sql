@@ -11,12 +11,108 @@ RETURNING *;
 
 -- name: GetAllChirps :many
 SELECT * FROM chirps
+WHERE hidden_at IS NULL AND deleted_at IS NULL
+AND NOT EXISTS (
+    SELECT 1 FROM user_suspensions
+    WHERE user_suspensions.user_id = chirps.user_id
+    AND (user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW())
+)
+ORDER BY created_at ASC;
+
+-- name: GetChirpsForViewer :many
+SELECT * FROM chirps
+WHERE hidden_at IS NULL AND deleted_at IS NULL
+AND NOT EXISTS (
+    SELECT 1 FROM user_suspensions
+    WHERE user_suspensions.user_id = chirps.user_id
+    AND (user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW())
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (user_blocks.blocker_id = chirps.user_id AND user_blocks.blocked_id = sqlc.arg(viewer_id))
+    OR (user_blocks.blocker_id = sqlc.arg(viewer_id) AND user_blocks.blocked_id = chirps.user_id)
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE user_mutes.muter_id = sqlc.arg(viewer_id) AND user_mutes.muted_id = chirps.user_id
+)
 ORDER BY created_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetChirpIncludingDeleted :one
+-- For moderation, which has to see chirps their author has since deleted.
+SELECT * FROM chirps
 WHERE id = $1;
 
+-- name: ImportChirp :one
+-- Imported chirps keep their original timestamp; a chirp already imported
+-- with the same external ID is skipped.
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, external_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+RETURNING *;
+
+-- name: ListChirpsForUser :many
+SELECT * FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
 -- name: DeleteChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: HideChirp :exec
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1 AND hidden_at IS NULL;
+
+-- name: UnhideChirp :exec
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1;
+
+-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET deleted_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetDeletedChirp :one
+SELECT * FROM chirps
+WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: SoftDeleteChirpsForUser :exec
+-- Chirps deleted along with their author share the author's deleted_at, so
+-- restoring the account brings back only those.
+UPDATE chirps
+SET deleted_at = $2
+WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: RestoreChirpsForUser :exec
+UPDATE chirps
+SET deleted_at = NULL
+WHERE user_id = $1 AND deleted_at = $2;
+
+-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE deleted_at < sqlc.arg(cutoff)::timestamp
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+);
\ No newline at end of file
@@ -0,0 +1,65 @@
+-- name: CreateNotification :exec
+-- Nothing is recorded for the user's own actions, types they've turned off,
+-- or actors they've muted or who are blocked either way.
+INSERT INTO notifications (id, created_at, user_id, actor_id, type, chirp_id, group_key)
+SELECT gen_random_uuid(), NOW(), sqlc.arg(user_id)::UUID, sqlc.arg(actor_id)::UUID, sqlc.arg(type)::TEXT, sqlc.narg(chirp_id)::UUID, sqlc.arg(group_key)::TEXT
+WHERE sqlc.arg(user_id)::UUID <> sqlc.arg(actor_id)::UUID
+AND NOT EXISTS (
+    SELECT 1 FROM notification_preferences
+    WHERE user_id = sqlc.arg(user_id) AND type = sqlc.arg(type) AND NOT enabled
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = sqlc.arg(user_id) AND blocked_id = sqlc.arg(actor_id))
+    OR (blocker_id = sqlc.arg(actor_id) AND blocked_id = sqlc.arg(user_id))
+)
+AND NOT EXISTS (
+    SELECT 1 FROM user_mutes
+    WHERE muter_id = sqlc.arg(user_id) AND muted_id = sqlc.arg(actor_id)
+)
+ON CONFLICT (user_id, group_key, actor_id) DO NOTHING;
+
+-- name: GetNotification :one
+SELECT * FROM notifications
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListNotificationGroups :many
+-- Each group is represented by its newest notification, with how many
+-- notifications it holds and how many of those are unread.
+SELECT id, created_at, user_id, actor_id, type, chirp_id, group_key, read_at, group_size, unread_count
+FROM (
+    SELECT DISTINCT ON (group_key) *,
+        COUNT(*) OVER (PARTITION BY group_key) AS group_size,
+        COUNT(*) FILTER (WHERE read_at IS NULL) OVER (PARTITION BY group_key) AS unread_count
+    FROM notifications
+    WHERE user_id = sqlc.arg(user_id)
+    AND (sqlc.narg(type)::TEXT IS NULL OR type = sqlc.narg(type))
+    ORDER BY group_key, created_at DESC
+) AS groups
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountUnreadNotificationGroups :one
+SELECT COUNT(DISTINCT group_key) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationGroupRead :exec
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1 AND group_key = $2 AND read_at IS NULL;
+
+-- name: MarkAllNotificationsRead :exec
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg(user_id) AND read_at IS NULL
+AND (sqlc.narg(type)::TEXT IS NULL OR type = sqlc.narg(type));
+
+-- name: ListNotificationPreferences :many
+SELECT * FROM notification_preferences
+WHERE user_id = $1;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled, updated_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (user_id, type) DO UPDATE
+SET enabled = EXCLUDED.enabled, updated_at = NOW();
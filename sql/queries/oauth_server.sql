@@ -0,0 +1,86 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, owner_id, name, secret_hash, redirect_uris)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    NULL
+);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOAuthRefreshToken :exec
+INSERT INTO oauth_refresh_tokens (token_hash, created_at, client_id, user_id, scopes, expires_at, revoked_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    NULL
+);
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM oauth_refresh_tokens
+WHERE token_hash = $1;
+
+-- name: RevokeOAuthRefreshToken :execrows
+UPDATE oauth_refresh_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllOAuthRefreshTokensForUser :exec
+UPDATE oauth_refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthAccessToken :exec
+INSERT INTO oauth_revoked_access_tokens (jti, expires_at)
+VALUES (
+    $1,
+    $2
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsOAuthAccessTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1 FROM oauth_revoked_access_tokens
+    WHERE jti = $1
+);
+
+-- name: DeleteExpiredOAuthGrants :exec
+WITH codes AS (
+    DELETE FROM oauth_authorization_codes
+    WHERE oauth_authorization_codes.expires_at < NOW() - INTERVAL '1 day'
+), revocations AS (
+    DELETE FROM oauth_revoked_access_tokens
+    WHERE oauth_revoked_access_tokens.expires_at < NOW()
+)
+DELETE FROM oauth_refresh_tokens
+WHERE oauth_refresh_tokens.expires_at < NOW() - INTERVAL '1 day';
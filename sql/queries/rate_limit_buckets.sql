@@ -0,0 +1,18 @@
+-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (
+    @key,
+    @capacity::float8 - 1,
+    TRUE,
+    NOW()
+)
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @refill_rate::float8)
+        - CASE WHEN LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @refill_rate::float8) >= 1 THEN 1 ELSE 0 END,
+    allowed = LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @refill_rate::float8) >= 1,
+    updated_at = NOW()
+RETURNING tokens, allowed;
+
+-- name: DeleteIdleRateLimitBuckets :exec
+DELETE FROM rate_limit_buckets
+WHERE updated_at < $1;
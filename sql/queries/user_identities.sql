@@ -0,0 +1,40 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1 AND subject = $2;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: CreateOAuthLoginState :exec
+INSERT INTO oauth_login_states (state, created_at, provider, nonce, code_verifier, link_user_id, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: UseOAuthLoginState :one
+DELETE FROM oauth_login_states
+WHERE state = $1 AND provider = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOAuthLoginStates :exec
+DELETE FROM oauth_login_states
+WHERE expires_at <= NOW();
@@ -0,0 +1,33 @@
+-- name: SuspendUser :exec
+INSERT INTO user_suspensions (user_id, created_at, suspended_by, reason, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (user_id) DO UPDATE
+SET created_at = NOW(),
+    suspended_by = EXCLUDED.suspended_by,
+    reason = EXCLUDED.reason,
+    expires_at = EXCLUDED.expires_at;
+
+-- name: GetActiveUserSuspension :one
+SELECT * FROM user_suspensions
+WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: ListActiveUserSuspensions :many
+SELECT user_suspensions.*, users.email
+FROM user_suspensions
+JOIN users ON users.id = user_suspensions.user_id
+WHERE user_suspensions.expires_at IS NULL OR user_suspensions.expires_at > NOW()
+ORDER BY user_suspensions.created_at DESC;
+
+-- name: DeleteUserSuspension :execrows
+DELETE FROM user_suspensions
+WHERE user_id = $1;
+
+-- name: DeleteExpiredUserSuspensions :exec
+DELETE FROM user_suspensions
+WHERE expires_at <= NOW();
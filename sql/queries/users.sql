@@ -14,14 +14,59 @@ DELETE FROM users;
 
 -- name: GetUser :one
 SELECT * FROM users
-WHERE $1 = id;
+WHERE $1 = id AND deleted_at IS NULL;
 
 -- name: GetUserByEmail :one
 SELECT * FROM users
-WHERE $1 = email;
+WHERE $1 = email AND deleted_at IS NULL;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3, updated_at = NOW()
-WHERE id = $1
-RETURNING *;
\ No newline at end of file
+SET email = $2,
+    hashed_password = $3,
+    email_verified = email_verified AND email = $2,
+    updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: MarkUserEmailVerified :one
+-- Only if the user still has the address that was verified.
+UPDATE users
+SET email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: GetDeletedUser :one
+SELECT * FROM users
+WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- name: GetDeletedUserByEmail :one
+SELECT * FROM users
+WHERE email = $1 AND deleted_at IS NOT NULL;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE id IN (
+    SELECT id FROM users
+    WHERE deleted_at < sqlc.arg(cutoff)::timestamp
+    AND NOT EXISTS (SELECT 1 FROM chirps WHERE chirps.user_id = users.id)
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+);
\ No newline at end of file
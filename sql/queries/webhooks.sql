@@ -0,0 +1,91 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (id, created_at, created_by, all_users, url, secret, event_types)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks
+WHERE id = $1;
+
+-- name: ListWebhooks :many
+-- A user's own webhooks, and with include_all_users every all-users webhook.
+SELECT * FROM webhooks
+WHERE created_by = sqlc.arg(user_id) OR (all_users AND sqlc.arg(include_all_users)::BOOLEAN)
+ORDER BY created_at ASC;
+
+-- name: DeleteWebhook :exec
+DELETE FROM webhooks
+WHERE id = $1;
+
+-- name: QueueWebhookDeliveries :execrows
+-- Queues an event for every webhook subscribed to its type that may see it:
+-- all-users webhooks, and those created by the user it's about.
+INSERT INTO webhook_deliveries (id, created_at, webhook_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), webhooks.id, sqlc.arg(event_id)::UUID, sqlc.arg(event_type)::TEXT, sqlc.arg(payload)::TEXT, 'pending', NOW()
+FROM webhooks
+WHERE sqlc.arg(event_type)::TEXT = ANY(webhooks.event_types)
+AND (webhooks.all_users OR webhooks.created_by = sqlc.narg(subject_id)::UUID);
+
+-- name: ClaimWebhookDelivery :one
+-- Picks the delivery longest overdue, or one whose worker seems to have died.
+UPDATE webhook_deliveries
+SET status = 'running', started_at = NOW(), attempts = attempts + 1
+WHERE id = (
+    SELECT id FROM webhook_deliveries
+    WHERE (status = 'pending' AND next_attempt_at <= NOW())
+    OR (status = 'running' AND started_at < NOW() - INTERVAL '5 minutes')
+    ORDER BY next_attempt_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, response_status, error, duration_ms)
+VALUES ($1, NOW(), $2, $3, $4);
+
+-- name: CompleteWebhookDelivery :exec
+-- Records the last attempt of a delivery that succeeded or ran out of retries.
+UPDATE webhook_deliveries
+SET status = $2, completed_at = NOW(), last_response_status = $3, last_error = $4
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET status = 'pending', next_attempt_at = $2, last_response_status = $3, last_error = $4
+WHERE id = $1;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1 AND webhook_id = $2;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE webhook_id = $1
+ORDER BY created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE delivery_id = $1
+ORDER BY attempted_at ASC;
+
+-- name: RedeliverWebhookDelivery :one
+-- Queues a fresh copy of a delivery, leaving the original and its log alone.
+INSERT INTO webhook_deliveries (id, created_at, webhook_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), webhook_id, event_id, event_type, payload, 'pending', NOW()
+FROM webhook_deliveries
+WHERE webhook_deliveries.id = $1
+RETURNING *;
+
+-- name: DeleteOldWebhookDeliveries :exec
+DELETE FROM webhook_deliveries
+WHERE completed_at < NOW() - INTERVAL '30 days';
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Promote accounts with e.g. UPDATE users SET role = 'admin' WHERE email = '...';
+ALTER TABLE users
+ADD role TEXT NOT NULL DEFAULT 'user'
+CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN role;
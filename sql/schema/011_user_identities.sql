@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE user_identities(
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    email TEXT NOT NULL,
+
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_login_states(
+    state TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    link_user_id UUID,
+    expires_at TIMESTAMP NOT NULL,
+
+    FOREIGN KEY (link_user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE oauth_login_states;
+DROP TABLE user_identities;
@@ -0,0 +1,61 @@
+-- +goose Up
+-- Hidden chirps are kept for review but left out of public listings
+ALTER TABLE chirps
+ADD hidden_at TIMESTAMP;
+
+CREATE TABLE chirp_reports(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL,
+    reporter_id UUID NOT NULL,
+    reason TEXT NOT NULL
+    CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'sexual', 'misinformation', 'other')),
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open'
+    CHECK (status IN ('open', 'claimed', 'resolved')),
+    claimed_by UUID,
+    claimed_at TIMESTAMP,
+    resolved_by UUID,
+    resolved_at TIMESTAMP,
+    resolution TEXT
+    CHECK (resolution IN ('dismiss', 'hide', 'delete', 'suspend')),
+
+    -- Each user can only report a chirp once
+    UNIQUE (chirp_id, reporter_id),
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (reporter_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (claimed_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL,
+    FOREIGN KEY (resolved_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+CREATE INDEX chirp_reports_status_idx ON chirp_reports (status, created_at);
+
+CREATE TABLE user_suspensions(
+    user_id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    suspended_by UUID,
+    reason TEXT NOT NULL,
+    -- NULL for a permanent ban
+    expires_at TIMESTAMP,
+
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (suspended_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+-- +goose Down
+DROP TABLE user_suspensions;
+DROP TABLE chirp_reports;
+ALTER TABLE chirps
+DROP COLUMN hidden_at;
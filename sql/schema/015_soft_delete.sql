@@ -0,0 +1,20 @@
+-- +goose Up
+-- Deleted rows stay restorable for a grace period, then the purger removes them
+ALTER TABLE chirps
+ADD deleted_at TIMESTAMP;
+
+ALTER TABLE users
+ADD deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_deleted_at_idx ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX users_deleted_at_idx;
+DROP INDEX chirps_deleted_at_idx;
+
+ALTER TABLE users
+DROP COLUMN deleted_at;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE data_exports(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    -- When a ready archive stops being downloadable and is deleted
+    expires_at TIMESTAMP,
+    error TEXT,
+    archive BYTEA,
+
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX data_exports_status_idx ON data_exports (status, created_at);
+
+-- +goose Down
+DROP TABLE data_exports;
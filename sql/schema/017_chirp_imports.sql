@@ -0,0 +1,12 @@
+-- +goose Up
+-- The ID a chirp had on the platform it was imported from
+ALTER TABLE chirps
+ADD external_id TEXT;
+
+CREATE UNIQUE INDEX chirps_user_external_id_idx ON chirps (user_id, external_id) WHERE external_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_user_external_id_idx;
+
+ALTER TABLE chirps
+DROP COLUMN external_id;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Numbers chirp stream events, so every instance gives an event the same ID
+CREATE SEQUENCE chirp_event_seq;
+
+-- +goose Down
+DROP SEQUENCE chirp_event_seq;
@@ -0,0 +1,48 @@
+-- +goose Up
+CREATE TABLE notifications(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    type TEXT NOT NULL
+    CHECK (type IN ('follow', 'like', 'reply', 'mention', 'rechirp')),
+    -- The chirp liked, rechirped, replied with or mentioning the user; NULL
+    -- for follows
+    chirp_id UUID,
+    -- Notifications with the same key are shown together
+    group_key TEXT NOT NULL,
+    read_at TIMESTAMP,
+
+    -- Repeating an action doesn't notify the user twice
+    UNIQUE (user_id, group_key, actor_id),
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (actor_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX notifications_user_idx ON notifications (user_id, created_at);
+CREATE INDEX notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
+
+-- Every type is on until the user turns it off
+CREATE TABLE notification_preferences(
+    user_id UUID NOT NULL,
+    type TEXT NOT NULL
+    CHECK (type IN ('follow', 'like', 'reply', 'mention', 'rechirp')),
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+
+    PRIMARY KEY (user_id, type),
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
@@ -0,0 +1,66 @@
+-- +goose Up
+CREATE TABLE webhooks(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    created_by UUID NOT NULL,
+    -- Sent every user's events if true, which only admins can set; otherwise
+    -- only events about the user who created it
+    all_users BOOLEAN NOT NULL,
+    url TEXT NOT NULL,
+    -- Kept in the clear because it's needed to sign every payload
+    secret TEXT NOT NULL,
+    event_types TEXT[] NOT NULL,
+
+    FOREIGN KEY (created_by)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX webhooks_created_by_idx ON webhooks (created_by);
+
+CREATE TABLE webhook_deliveries(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    webhook_id UUID NOT NULL,
+    -- Shared by every delivery of the same event, redeliveries included, so
+    -- receivers can ignore duplicates
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    -- The outcome of the latest attempt; the status is NULL if no response
+    -- was received
+    last_response_status INTEGER,
+    last_error TEXT,
+
+    FOREIGN KEY (webhook_id)
+    REFERENCES webhooks(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status IN ('pending', 'running');
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE TABLE webhook_delivery_attempts(
+    delivery_id UUID NOT NULL,
+    attempted_at TIMESTAMP NOT NULL,
+    response_status INTEGER,
+    error TEXT,
+    duration_ms INTEGER NOT NULL,
+
+    FOREIGN KEY (delivery_id)
+    REFERENCES webhook_deliveries(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_delivery_attempts_delivery_idx ON webhook_delivery_attempts (delivery_id, attempted_at);
+
+-- +goose Down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- A token only verifies the address it was mailed to
+ALTER TABLE email_verification_tokens
+ADD email TEXT NOT NULL DEFAULT '';
+
+-- Outstanding tokens issued before the user's last update may be for an
+-- address they've since changed, so those are left unusable
+UPDATE email_verification_tokens
+SET email = users.email
+FROM users
+WHERE users.id = email_verification_tokens.user_id
+AND email_verification_tokens.created_at >= users.updated_at;
+
+ALTER TABLE email_verification_tokens
+ALTER email DROP DEFAULT;
+
+-- +goose Down
+ALTER TABLE email_verification_tokens
+DROP COLUMN email;
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/sso"
+)
+
+const oauthLoginStateTTL = 10 * time.Minute
+
+// ssoStateCookie carries the login state in the browser that started the
+// flow.  The callback only accepts a state that browser presents, so nobody
+// can finish their own login in someone else's browser (RFC 6749 section
+// 10.12), which would sign the victim into the attacker's account or link
+// the attacker's identity to the victim's.
+const ssoStateCookie = "chirpy_sso_state"
+
+// unusablePasswordHash is stored for accounts created through an identity
+// provider.  No hasher recognizes it, so password login always fails.
+const unusablePasswordHash = "!sso"
+
+// loadSSOProviders discovers each provider named in OIDC_PROVIDERS (comma
+// separated), configured by OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and
+// OIDC_<NAME>_CLIENT_SECRET.  Callbacks go to
+// <BASE_URL>/api/auth/<name>/callback, which must be registered with the provider.
+func loadSSOProviders(ctx context.Context) (map[string]*sso.Provider, error) {
+	providers := map[string]*sso.Provider{}
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if baseURL == "" {
+			return nil, errors.New("BASE_URL must be set to use OIDC_PROVIDERS")
+		}
+		env := "OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := sso.NewProvider(ctx, name,
+			os.Getenv(env+"ISSUER"),
+			os.Getenv(env+"CLIENT_ID"),
+			os.Getenv(env+"CLIENT_SECRET"),
+			baseURL+"/api/auth/"+name+"/callback",
+		)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// startSSOLogin records a single-use login state, binds it to the browser
+// with a cookie and returns the provider's authorization URL.  linkUserID is
+// set when an existing user is linking an identity.
+func (cfg *apiConfig) startSSOLogin(wrt http.ResponseWriter, req *http.Request, provider *sso.Provider, linkUserID uuid.NullUUID) (string, error) {
+	state, nonce, codeVerifier := sso.NewLoginState()
+	err := cfg.db.CreateOAuthLoginState(req.Context(), database.CreateOAuthLoginStateParams{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oauthLoginStateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	setSSOStateCookie(wrt, provider, state, int(oauthLoginStateTTL.Seconds()))
+	return provider.AuthCodeURL(state, nonce, codeVerifier), nil
+}
+
+// setSSOStateCookie sets the state cookie, or clears it when maxAge is
+// negative.  Lax lets it ride along on the provider's redirect back.
+func setSSOStateCookie(wrt http.ResponseWriter, provider *sso.Provider, state string, maxAge int) {
+	http.SetCookie(wrt, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/api/auth/" + provider.Name + "/",
+		MaxAge:   maxAge,
+		Secure:   provider.SecureCallback(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (cfg *apiConfig) handlerSSOLogin(wrt http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.ssoProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(wrt, 404, fmt.Sprintf("Unknown identity provider %s", req.PathValue("provider")))
+		return
+	}
+
+	url, err := cfg.startSSOLogin(wrt, req, provider, uuid.NullUUID{})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting SSO login", "provider", provider.Name, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	http.Redirect(wrt, req, url, http.StatusFound)
+}
+
+// handlerSSOLink starts the flow for a logged-in user to attach an external
+// identity.  It returns the URL as JSON since the caller authenticates with a
+// header, and the browser that made the call must be the one that follows it.
+func (cfg *apiConfig) handlerSSOLink(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	provider, ok := cfg.ssoProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(wrt, 404, fmt.Sprintf("Unknown identity provider %s", req.PathValue("provider")))
+		return
+	}
+
+	url, err := cfg.startSSOLogin(wrt, req, provider, uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting SSO link", "provider", provider.Name, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	respondWithJSON(wrt, 200, ssoAuthorization{
+		AuthorizationURL: url,
+	})
+}
+
+func (cfg *apiConfig) handlerSSOCallback(wrt http.ResponseWriter, req *http.Request) {
+	provider, ok := cfg.ssoProviders[req.PathValue("provider")]
+	if !ok {
+		respondWithError(wrt, 404, fmt.Sprintf("Unknown identity provider %s", req.PathValue("provider")))
+		return
+	}
+	query := req.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(wrt, 401, fmt.Sprintf("%s login failed: %s", provider.Name, errCode))
+		return
+	}
+
+	cookie, err := req.Cookie(ssoStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithError(wrt, 400, "Login state is invalid or expired")
+		return
+	}
+	setSSOStateCookie(wrt, provider, "", -1)
+
+	state, err := cfg.db.UseOAuthLoginState(req.Context(), database.UseOAuthLoginStateParams{
+		State:    query.Get("state"),
+		Provider: provider.Name,
+	})
+	if err != nil {
+		respondWithError(wrt, 400, "Login state is invalid or expired")
+		return
+	}
+	identity, err := provider.Exchange(req.Context(), query.Get("code"), state.Nonce, state.CodeVerifier)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error completing SSO login", "provider", provider.Name, "error", err)
+		respondWithError(wrt, 401, fmt.Sprintf("%s login failed", provider.Name))
+		return
+	}
+
+	existing, err := cfg.db.GetUserIdentity(req.Context(), database.GetUserIdentityParams{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(req.Context(), "Error getting SSO identity", "provider", provider.Name, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	found := err == nil
+
+	if state.LinkUserID.Valid {
+		if found && existing.UserID != state.LinkUserID.UUID {
+			respondWithError(wrt, 409, fmt.Sprintf("This %s account is linked to another user", provider.Name))
+			return
+		}
+		if !found {
+			if _, err := cfg.linkIdentity(req.Context(), state.LinkUserID.UUID, identity); err != nil {
+				slog.ErrorContext(req.Context(), "Error linking SSO identity", "provider", provider.Name, "error", err)
+				wrt.WriteHeader(500)
+				return
+			}
+		}
+		respondWithJSON(wrt, 200, LinkedIdentity{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+		return
+	}
+
+	var user database.User
+	if found {
+		user, err = cfg.db.GetUser(req.Context(), existing.UserID)
+	} else {
+		user, err = cfg.ssoSignUp(req.Context(), identity)
+	}
+	if errors.Is(err, errEmailTaken) {
+		respondWithError(wrt, 409, "An account with this email already exists; log in and link this provider instead")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error resolving SSO user", "provider", provider.Name, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	cfg.completeLogin(wrt, req, user)
+}
+
+var errEmailTaken = errors.New("email belongs to an existing account")
+
+// ssoSignUp finds or creates the user for an identity seen for the first time.
+// An existing account is only linked automatically when both the provider and
+// we have verified the email.  Otherwise anyone could claim an account by
+// typing its address, or register someone's address before they first sign
+// in with SSO and keep a password into their account.
+func (cfg *apiConfig) ssoSignUp(ctx context.Context, identity sso.Identity) (database.User, error) {
+	user, err := cfg.db.GetUserByEmail(ctx, identity.Email)
+	if err == nil {
+		if !identity.EmailVerified || !user.EmailVerified {
+			return database.User{}, errEmailTaken
+		}
+		_, err := cfg.linkIdentity(ctx, user.ID, identity)
+		return user, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !validateEmail(identity.Email) {
+		return database.User{}, fmt.Errorf("provider returned invalid email %q", identity.Email)
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+		Email:          identity.Email,
+		HashedPassword: unusablePasswordHash,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	if identity.EmailVerified {
+		if user, err = qtx.MarkUserEmailVerified(ctx, database.MarkUserEmailVerifiedParams{
+			ID:    user.ID,
+			Email: user.Email,
+		}); err != nil {
+			return database.User{}, err
+		}
+	}
+	if _, err := qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   user.ID,
+		Email:    identity.Email,
+	}); err != nil {
+		return database.User{}, err
+	}
+	if err := queueWebhookEvent(ctx, qtx, webhookEventUserCreated, uuid.NullUUID{UUID: user.ID, Valid: true}, webhookUser{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}); err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
+
+func (cfg *apiConfig) linkIdentity(ctx context.Context, userID uuid.UUID, identity sso.Identity) (database.UserIdentity, error) {
+	return cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   userID,
+		Email:    identity.Email,
+	})
+}
+
+func (cfg *apiConfig) handlerListIdentities(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	identities, err := cfg.db.ListUserIdentities(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing identities", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	linked := make([]LinkedIdentity, len(identities))
+	for i, identity := range identities {
+		linked[i] = LinkedIdentity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		}
+	}
+	respondWithJSON(wrt, 200, linked)
+}
+
+// pruneOAuthLoginStates periodically deletes abandoned login attempts.
+func (cfg *apiConfig) pruneOAuthLoginStates(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cfg.db.DeleteExpiredOAuthLoginStates(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error deleting expired OAuth login states", "error", err)
+		}
+		cancel()
+	}
+}
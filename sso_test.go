@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/sso"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider: discovery, JWKS and a
+// token endpoint that checks PKCE and returns an RS256-signed ID token.
+type mockOIDCProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	m := &mockOIDCProvider{key: key, clientID: "chirpy"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(wrt http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(wrt).Encode(map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(wrt http.ResponseWriter, _ *http.Request) {
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(wrt).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   b64.EncodeToString(key.N.Bytes()),
+				"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(wrt http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		sum := sha256.Sum256([]byte(req.PostForm.Get("code_verifier")))
+		if req.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			wrt.WriteHeader(400)
+			json.NewEncoder(wrt).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            m.server.URL,
+			"aud":            m.clientID,
+			"sub":            "user-1234",
+			"email":          "saul@bettercall.com",
+			"email_verified": true,
+			"nonce":          m.nonce,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		wrt.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(wrt).Encode(map[string]any{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the part of the user approving the login in their browser.
+func (m *mockOIDCProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("error parsing authorization URL: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") == "" {
+		t.Errorf("authorization URL is missing PKCE or state: %s", authURL)
+	}
+	m.challenge = query.Get("code_challenge")
+	m.nonce = query.Get("nonce")
+}
+
+func TestSSOProviderExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := sso.NewProvider(ctx, "mock", mock.server.URL, mock.clientID, "secret", "http://localhost:8080/api/auth/mock/callback")
+	if err != nil {
+		t.Fatalf("error creating provider: %v", err)
+	}
+
+	state, nonce, verifier := sso.NewLoginState()
+	mock.authorize(t, provider.AuthCodeURL(state, nonce, verifier))
+
+	identity, err := provider.Exchange(ctx, "good-code", nonce, verifier)
+	if err != nil {
+		t.Fatalf("error exchanging code: %v", err)
+	}
+	if identity.Provider != "mock" || identity.Subject != "user-1234" || identity.Email != "saul@bettercall.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestSSOProviderRejectsBadPKCEAndNonce(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := sso.NewProvider(ctx, "mock", mock.server.URL, mock.clientID, "secret", "http://localhost:8080/api/auth/mock/callback")
+	if err != nil {
+		t.Fatalf("error creating provider: %v", err)
+	}
+
+	state, nonce, verifier := sso.NewLoginState()
+	mock.authorize(t, provider.AuthCodeURL(state, nonce, verifier))
+
+	if _, err := provider.Exchange(ctx, "good-code", nonce, "wrong-verifier"); err == nil {
+		t.Errorf("expected exchange with the wrong code verifier to fail")
+	}
+	if _, err := provider.Exchange(ctx, "good-code", "wrong-nonce", verifier); err == nil {
+		t.Errorf("expected exchange with the wrong nonce to fail")
+	}
+}
+
+func TestSSOSignUpOnlyLinksVerifiedAccounts(t *testing.T) {
+	identity := sso.Identity{Provider: "test", Subject: "123", Email: "saul@bettercall.com", EmailVerified: true}
+	for _, c := range []struct {
+		localVerified bool
+		linked        bool
+	}{
+		{true, true},
+		// Someone may have registered the address first to keep a way in
+		{false, false},
+	} {
+		db := newFakeDB(map[string][]driver.Value{
+			"GetUserByEmail": userRow(uuid.New(), identity.Email, c.localVerified, roleUser),
+		})
+		cfg := &apiConfig{db: db.queries()}
+		_, err := cfg.ssoSignUp(context.Background(), identity)
+		if linked := db.called("CreateUserIdentity"); linked != c.linked {
+			t.Errorf("local account verified %v: expected linked %v, got %v", c.localVerified, c.linked, linked)
+		}
+		if !c.linked && !errors.Is(err, errEmailTaken) {
+			t.Errorf("expected errEmailTaken, got %v", err)
+		}
+	}
+}
+
+func TestSSOCallbackRequiresStateCookie(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider, err := sso.NewProvider(context.Background(), "mock", mock.server.URL, mock.clientID, "secret", "http://localhost:8080/api/auth/mock/callback")
+	if err != nil {
+		t.Fatalf("error creating provider: %v", err)
+	}
+	db := newFakeDB(map[string][]driver.Value{
+		"CreateOAuthLoginState": nil,
+	})
+	cfg := db.apiConfig()
+	cfg.ssoProviders = map[string]*sso.Provider{"mock": provider}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/auth/{provider}/login", cfg.handlerSSOLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", cfg.handlerSSOCallback)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/auth/mock/login", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != ssoStateCookie || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected an HttpOnly, SameSite=Lax state cookie, got %v", cookies)
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	state := location.Query().Get("state")
+	if cookies[0].Value != state {
+		t.Errorf("expected the cookie to hold the state %s, got %s", state, cookies[0].Value)
+	}
+
+	// An attacker's callback URL, opened in a browser that never started a login
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":    nil,
+		"other cookie": {Name: ssoStateCookie, Value: "some-other-state"},
+	} {
+		req := httptest.NewRequest("GET", "/api/auth/mock/callback?code=good-code&state="+url.QueryEscape(state), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+	if db.called("UseOAuthLoginState") {
+		t.Error("expected the login state to be left unused")
+	}
+}
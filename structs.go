@@ -1,32 +1,372 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/netip"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
 	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/lockout"
+	"github.com/nfongster/chirpy/internal/mailer"
+	"github.com/nfongster/chirpy/internal/ratelimit"
+	"github.com/nfongster/chirpy/internal/sso"
+	"github.com/nfongster/chirpy/internal/stream"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	secret         string
+	// fileserverHitsReset is the hit count when the admin page was last reset
+	fileserverHitsReset atomic.Int64
+	db                  *database.Queries
+	dbConn              *sql.DB
+	secret              string
+	mailer              mailer.Mailer
+	passwordPolicy      *auth.PasswordPolicy
+	hashers             *auth.Hashers
+	totp                *auth.TOTP
+	// dummyHash is verified against when a login names an unknown email
+	dummyHash       string
+	accountLockouts *lockout.Tracker
+	ipLockouts      *lockout.Tracker
+	rateLimiter     ratelimit.Store
+	// apiKeyIdentities caches the API keys the rate limiter has looked up
+	apiKeyIdentities *ratelimit.IdentityCache
+	trustedProxies   []netip.Prefix
+	ssoProviders     map[string]*sso.Provider
+	// reportThreshold unresolved reports hide a chirp pending review
+	reportThreshold int
+	// deletionGracePeriod is how long soft-deleted rows can be restored
+	deletionGracePeriod time.Duration
+	// exportWake nudges the data export worker when a job is queued
+	exportWake chan struct{}
+	// chirpHub fans chirp events out to stream clients
+	chirpHub *stream.Hub[Chirp]
+	// shuttingDown makes the readiness probe fail while the server drains
+	shuttingDown atomic.Bool
+	// webhookClient delivers to all-users webhooks, which admins may point
+	// at internal services; userWebhookClient only reaches public addresses
+	webhookClient     *http.Client
+	userWebhookClient *http.Client
 }
 
 type chirpError struct {
 	Error string `json:"error"`
 }
 
+type passwordPolicyError struct {
+	Error      string                 `json:"error"`
+	Violations []auth.PolicyViolation `json:"violations"`
+}
+
+type suspensionError struct {
+	Error     string     `json:"error"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// exportData is the contents of a data export archive
+type exportData struct {
+	GeneratedAt time.Time
+	Profile     exportProfile
+	Chirps      []exportChirp
+	Sessions    []exportSession
+	APIKeys     []APIKey
+	Identities  []LinkedIdentity
+	Blocks      []Relationship
+	Mutes       []Relationship
+}
+
+type exportProfile struct {
+	ID               uuid.UUID `json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	Role             string    `json:"role"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+}
+
+type exportChirp struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body"`
+	Hidden    bool       `json:"hidden"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type exportSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
 // JSON PACKETS SENT BY SERVER
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+}
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type totpRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type lockoutList struct {
+	Accounts []lockout.Status `json:"accounts"`
+	IPs      []lockout.Status `json:"ips"`
+}
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+type ssoAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type LinkedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Public       bool      `json:"public"`
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type oauthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+type ChirpReport struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ChirpID    uuid.UUID  `json:"chirp_id"`
+	ReporterID uuid.UUID  `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	Status     string     `json:"status"`
+	ClaimedBy  *uuid.UUID `json:"claimed_by"`
+	ClaimedAt  *time.Time `json:"claimed_at"`
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+type reportQueueEntry struct {
+	ChirpReport
+	ChirpBody   string    `json:"chirp_body"`
+	AuthorID    uuid.UUID `json:"author_id"`
+	ChirpHidden bool      `json:"chirp_hidden"`
+	// ChirpDeleted is set when the author has deleted the chirp since
+	ChirpDeleted bool `json:"chirp_deleted"`
+}
+
+type Suspension struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SuspendedBy *uuid.UUID `json:"suspended_by"`
+	Reason      string     `json:"reason"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type Relationship struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type accountDeletion struct {
+	DeletedAt     time.Time `json:"deleted_at"`
+	RestoreBefore time.Time `json:"restore_before"`
+}
+
+type DataExport struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// chirpImportReport says what happened to each line of an import
+type chirpImportReport struct {
+	Imported   int                 `json:"imported"`
+	Duplicates int                 `json:"duplicates"`
+	Failed     int                 `json:"failed"`
+	Lines      []chirpImportResult `json:"lines"`
+}
+
+type chirpImportResult struct {
+	Line       int        `json:"line"`
+	ExternalID string     `json:"external_id,omitempty"`
+	Status     string     `json:"status"`
+	ChirpID    *uuid.UUID `json:"chirp_id,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// chirpImportFailure is the response when an import couldn't be read to the
+// end.  Lines before that point may already have been imported.
+type chirpImportFailure struct {
+	Error  string            `json:"error"`
+	Report chirpImportReport `json:"report"`
+}
+
+type readinessReport struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+type readinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// chirpEventMessage is the payload of a chirp_events notification
+type chirpEventMessage struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Chirp Chirp  `json:"chirp"`
+}
+
+// NotificationGroup is one entry in a user's inbox: a notification, or
+// several alike shown together ("X and 4 others liked your chirp").  Its
+// fields describe the newest of them.
+type NotificationGroup struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Type        string     `json:"type"`
+	ChirpID     *uuid.UUID `json:"chirp_id,omitempty"`
+	ActorID     uuid.UUID  `json:"actor_id"`
+	OtherActors int64      `json:"other_actors"`
+	Unread      bool       `json:"unread"`
+}
+
+type unreadNotificationCount struct {
+	UnreadCount int64 `json:"unread_count"`
+}
+
+type webhookParameters struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	AllUsers   bool     `json:"all_users"`
+}
+
+type Webhook struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	AllUsers   bool      `json:"all_users"`
+	Secret     string    `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID                 uuid.UUID  `json:"id"`
+	CreatedAt          time.Time  `json:"created_at"`
+	EventID            uuid.UUID  `json:"event_id"`
+	EventType          string     `json:"event_type"`
+	Status             string     `json:"status"`
+	Attempts           int32      `json:"attempts"`
+	NextAttemptAt      *time.Time `json:"next_attempt_at,omitempty"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
+	LastResponseStatus *int32     `json:"last_response_status,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	// The payload and attempt log are only shown for a single delivery
+	Payload    json.RawMessage          `json:"payload,omitempty"`
+	AttemptLog []WebhookDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+type WebhookDeliveryAttempt struct {
+	AttemptedAt    time.Time `json:"attempted_at"`
+	ResponseStatus *int32    `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	DurationMs     int32     `json:"duration_ms"`
+}
+
+// webhookPayload is the body of every webhook delivery
+type webhookPayload struct {
+	// ID is the event's, the same for every delivery of it
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// webhookUser is the data of user events
+type webhookUser struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+// wsClientMessage is a request sent over a WebSocket connection
+type wsClientMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// wsServerMessage is anything the server sends over a WebSocket connection:
+// a reply to a client message, or an event on a subscribed channel.
+type wsServerMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Event   string `json:"event,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Data    *Chirp `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 type Chirp struct {
@@ -47,3 +387,64 @@ type userParameters struct {
 type chirpParameters struct {
 	Body string `json:"body"`
 }
+
+// chirpImportLine is one line of a JSON Lines import
+type chirpImportLine struct {
+	ExternalID string     `json:"external_id"`
+	Body       string     `json:"body"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+type verifyParameters struct {
+	Token string `json:"token"`
+}
+
+type passwordResetRequestParameters struct {
+	Email string `json:"email"`
+}
+
+type passwordResetConfirmParameters struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type totpParameters struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type loginMFAParameters struct {
+	MFAToken string `json:"mfa_token"`
+	totpParameters
+}
+
+type apiKeyParameters struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type oauthClientParameters struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+}
+
+type reportParameters struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type resolveReportParameters struct {
+	Action      string `json:"action"`
+	Reason      string `json:"reason"`
+	SuspendDays int    `json:"suspend_days"`
+}
+
+type suspendParameters struct {
+	Reason string `json:"reason"`
+	Days   int    `json:"days"`
+}
+
+type deleteAccountParameters struct {
+	Password string `json:"password"`
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// suspendedError is returned when authenticating a user who is suspended.
+type suspendedError struct {
+	suspension database.UserSuspension
+}
+
+func (e *suspendedError) Error() string {
+	return "account is suspended"
+}
+
+// checkSuspension returns a *suspendedError if userID is currently suspended.
+func (cfg *apiConfig) checkSuspension(ctx context.Context, userID uuid.UUID) error {
+	suspension, err := cfg.db.GetActiveUserSuspension(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &suspendedError{suspension: suspension}
+}
+
+// checkActive returns an error if userID's account has been deleted or is
+// suspended.  It's used for credentials that outlive a session, like API keys
+// and OAuth access tokens.
+func (cfg *apiConfig) checkActive(ctx context.Context, userID uuid.UUID) error {
+	if _, err := cfg.db.GetUser(ctx, userID); err != nil {
+		return err
+	}
+	return cfg.checkSuspension(ctx, userID)
+}
+
+func respondWithSuspension(wrt http.ResponseWriter, suspension database.UserSuspension) {
+	resp := suspensionError{
+		Error:  "Account is suspended",
+		Reason: suspension.Reason,
+	}
+	if suspension.ExpiresAt.Valid {
+		resp.ExpiresAt = &suspension.ExpiresAt.Time
+	}
+	respondWithJSON(wrt, 403, resp)
+}
+
+// respondWithAuthError writes the response for a failed authentication:
+// 403 explaining the suspension for suspended accounts or the missing scope
+// for API keys, 401 otherwise.
+func respondWithAuthError(wrt http.ResponseWriter, err error) {
+	var suspended *suspendedError
+	if errors.As(err, &suspended) {
+		respondWithSuspension(wrt, suspended.suspension)
+		return
+	}
+	var missingScope *missingScopeError
+	if errors.As(err, &missingScope) {
+		respondWithError(wrt, 403, missingScope.Error())
+		return
+	}
+	wrt.WriteHeader(401)
+}
+
+// checkNotSuspended writes a 403 and returns false if userID is suspended.
+// It's used wherever new tokens would be issued.
+func (cfg *apiConfig) checkNotSuspended(wrt http.ResponseWriter, req *http.Request, userID uuid.UUID) bool {
+	err := cfg.checkSuspension(req.Context(), userID)
+	var suspended *suspendedError
+	if errors.As(err, &suspended) {
+		respondWithSuspension(wrt, suspended.suspension)
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error checking suspension of user", "user_id", userID, "error", err)
+		wrt.WriteHeader(500)
+		return false
+	}
+	return true
+}
+
+// suspendUser suspends userID for days (permanently if 0) and signs out
+// every session they have.
+func suspendUser(ctx context.Context, q *database.Queries, userID, suspendedBy uuid.UUID, reason string, days int) error {
+	if reason == "" {
+		reason = "Violation of the content rules"
+	}
+	expiresAt := sql.NullTime{}
+	if days > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, days), Valid: true}
+	}
+	if err := q.SuspendUser(ctx, database.SuspendUserParams{
+		UserID:      userID,
+		SuspendedBy: uuid.NullUUID{UUID: suspendedBy, Valid: true},
+		Reason:      reason,
+		ExpiresAt:   expiresAt,
+	}); err != nil {
+		return err
+	}
+	return q.RevokeAllRefreshTokensForUser(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+}
+
+func (cfg *apiConfig) handlerListSuspensions(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleAdmin); !ok {
+		return
+	}
+
+	rows, err := cfg.db.ListActiveUserSuspensions(req.Context())
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing suspensions", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	suspensions := make([]Suspension, len(rows))
+	for i, row := range rows {
+		suspensions[i] = Suspension{
+			UserID:    row.UserID,
+			Email:     row.Email,
+			CreatedAt: row.CreatedAt,
+			Reason:    row.Reason,
+		}
+		if row.SuspendedBy.Valid {
+			suspensions[i].SuspendedBy = &row.SuspendedBy.UUID
+		}
+		if row.ExpiresAt.Valid {
+			suspensions[i].ExpiresAt = &row.ExpiresAt.Time
+		}
+	}
+	respondWithJSON(wrt, 200, suspensions)
+}
+
+func (cfg *apiConfig) handlerSuspendUser(wrt http.ResponseWriter, req *http.Request) {
+	admin, ok := cfg.authorize(wrt, req, roleAdmin)
+	if !ok {
+		return
+	}
+
+	userId, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("userID")))
+		return
+	}
+	if userId == admin.ID {
+		respondWithError(wrt, 400, "You cannot suspend yourself")
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := suspendParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.Reason == "" {
+		respondWithError(wrt, 400, "No reason was supplied")
+		return
+	}
+	if params.Days < 0 {
+		respondWithError(wrt, 400, "days cannot be negative")
+		return
+	}
+	if _, err := cfg.db.GetUser(req.Context(), userId); err != nil {
+		respondWithError(wrt, 404, fmt.Sprintf("No user found for id %v", userId))
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	if err := suspendUser(req.Context(), cfg.withTx(tx), userId, admin.ID, params.Reason, params.Days); err != nil {
+		slog.ErrorContext(req.Context(), "Error suspending user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing suspension", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnsuspendUser(wrt http.ResponseWriter, req *http.Request) {
+	if _, ok := cfg.authorize(wrt, req, roleAdmin); !ok {
+		return
+	}
+
+	userId, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("userID")))
+		return
+	}
+	rows, err := cfg.db.DeleteUserSuspension(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error lifting suspension of user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		respondWithError(wrt, 404, fmt.Sprintf("User %v is not suspended", userId))
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// pruneSuspensions periodically deletes suspensions that have run out.
+func (cfg *apiConfig) pruneSuspensions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cfg.db.DeleteExpiredUserSuspensions(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error deleting expired suspensions", "error", err)
+		}
+		cancel()
+	}
+}
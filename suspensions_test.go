@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+func TestRespondWithAuthError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	respondWithAuthError(rec, errors.New("token is expired"))
+	if rec.Code != 401 {
+		t.Errorf("expected 401 for an invalid token, got %d", rec.Code)
+	}
+
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	rec = httptest.NewRecorder()
+	respondWithAuthError(rec, &suspendedError{suspension: database.UserSuspension{
+		Reason:    "Spam",
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+	}})
+	if rec.Code != 403 {
+		t.Errorf("expected 403 for a suspended account, got %d", rec.Code)
+	}
+	var body suspensionError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if body.Reason != "Spam" || body.ExpiresAt == nil || !body.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected suspension response %+v", body)
+	}
+}
+
+// suspensionRow is a user_suspensions row for a permanent ban
+func suspensionRow(userID uuid.UUID) []driver.Value {
+	return []driver.Value{userID.String(), time.Now(), nil, "Spam", nil}
+}
+
+func TestMiddlewareAuthRejectsSuspendedUsers(t *testing.T) {
+	userID := uuid.New()
+	cfg := newFakeDB(map[string][]driver.Value{
+		"GetUser":                 userRow(userID, "saul@bettercall.com", true, roleUser),
+		"GetActiveUserSuspension": suspensionRow(userID),
+	}).apiConfig()
+	handler := cfg.middlewareAuth(scopeChirpsWrite, func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(204)
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, requestAs(t, cfg, userID, "POST", "/api/chirps", ""))
+	if rec.Code != 403 {
+		t.Errorf("expected 403 for a suspended user's access token, got %d", rec.Code)
+	}
+}
+
+func TestHandlerRefreshRejectsSuspendedUsers(t *testing.T) {
+	userID := uuid.New()
+	refreshToken := []driver.Value{"token", time.Now(), time.Now(), userID.String(), time.Now().Add(time.Hour), nil}
+	for _, c := range []struct {
+		suspended bool
+		want      int
+	}{
+		{false, 200},
+		{true, 403},
+	} {
+		rows := map[string][]driver.Value{"GetRefreshToken": refreshToken}
+		if c.suspended {
+			rows["GetActiveUserSuspension"] = suspensionRow(userID)
+		}
+		cfg := newFakeDB(rows).apiConfig()
+
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		cfg.handlerRefresh(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("suspended %v: expected %d, got %d", c.suspended, c.want, rec.Code)
+		}
+	}
+}
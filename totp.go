@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// handlerTOTPEnroll starts two-factor enrollment by generating a new secret.
+// Enrollment only takes effect once a first code is confirmed.
+func (cfg *apiConfig) handlerTOTPEnroll(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		wrt.WriteHeader(401)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error generating TOTP secret", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	_, err = cfg.db.UpsertUserTOTP(req.Context(), database.UpsertUserTOTPParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving TOTP secret for user", "user_id", user.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	respondWithJSON(wrt, 200, totpEnrollment{
+		Secret: secret,
+		URI:    cfg.totp.URI(user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := totpParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(req.Context(), userId)
+	if err != nil {
+		respondWithError(wrt, 400, "Two-factor enrollment has not been started")
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		respondWithError(wrt, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	step, ok := cfg.totp.Validate(totp.Secret, params.Code)
+	if !ok {
+		respondWithError(wrt, 400, "Invalid code")
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error generating recovery codes", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	if err := qtx.ConfirmUserTOTP(req.Context(), database.ConfirmUserTOTPParams{
+		UserID:       userId,
+		LastUsedStep: sql.NullInt64{Int64: step, Valid: true},
+	}); err != nil {
+		slog.ErrorContext(req.Context(), "Error confirming TOTP for user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := qtx.DeleteRecoveryCodesForUser(req.Context(), userId); err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting recovery codes for user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	for _, code := range codes {
+		if err := qtx.CreateRecoveryCode(req.Context(), database.CreateRecoveryCodeParams{
+			UserID:   userId,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		}); err != nil {
+			slog.ErrorContext(req.Context(), "Error saving recovery code for user", "user_id", userId, "error", err)
+			wrt.WriteHeader(500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing TOTP confirmation", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Recovery codes are only ever shown here
+	respondWithJSON(wrt, 200, totpRecoveryCodes{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerTOTPDisable turns off two-factor auth.  It requires a current code or
+// an unused recovery code, so a stolen access token alone can't remove it.
+func (cfg *apiConfig) handlerTOTPDisable(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := totpParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(req.Context(), userId)
+	if err != nil {
+		respondWithError(wrt, 404, "Two-factor authentication is not enabled")
+		return
+	}
+	if totp.ConfirmedAt.Valid && !cfg.checkSecondFactor(req, totp, params) {
+		respondWithError(wrt, 401, "Invalid code")
+		return
+	}
+
+	if err := cfg.db.DeleteUserTOTP(req.Context(), userId); err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting TOTP for user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := cfg.db.DeleteRecoveryCodesForUser(req.Context(), userId); err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting recovery codes for user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+// handlerLoginMFA completes a two-step login, exchanging the challenge token
+// from POST /api/login and a TOTP or recovery code for the usual token pair.
+func (cfg *apiConfig) handlerLoginMFA(wrt http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	params := loginMFAParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	userId, err := auth.ValidateMFAChallengeToken(params.MFAToken, cfg.secret)
+	if err != nil {
+		respondWithError(wrt, 401, "MFA challenge is invalid or expired")
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(req.Context(), userId)
+	if err != nil || !totp.ConfirmedAt.Valid {
+		respondWithError(wrt, 401, "MFA challenge is invalid or expired")
+		return
+	}
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting user", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Codes are short, so guesses count towards the same lockout as passwords
+	if !cfg.checkLoginAllowed(wrt, req, user.Email) {
+		return
+	}
+	if !cfg.checkSecondFactor(req, totp, params.totpParameters) {
+		cfg.recordLoginFailure(req, user.Email)
+		respondWithError(wrt, 401, "Invalid code")
+		return
+	}
+	cfg.accountLockouts.Success(accountLockoutKey(user.Email))
+	if !cfg.checkNotSuspended(wrt, req, user.ID) {
+		return
+	}
+	session, err := cfg.newSession(req.Context(), user)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating session", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	logins.Inc()
+	respondWithJSON(wrt, 200, session)
+}
+
+// checkSecondFactor accepts either a TOTP code or a recovery code and consumes
+// it, so neither can be replayed.
+func (cfg *apiConfig) checkSecondFactor(req *http.Request, totp database.UserTotp, params totpParameters) bool {
+	if params.RecoveryCode != "" {
+		rows, err := cfg.db.UseRecoveryCode(req.Context(), database.UseRecoveryCodeParams{
+			UserID:   totp.UserID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode)),
+		})
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error using recovery code for user", "user_id", totp.UserID, "error", err)
+			return false
+		}
+		return rows == 1
+	}
+
+	step, ok := cfg.totp.Validate(totp.Secret, params.Code)
+	if !ok {
+		return false
+	}
+	// Only accept each time step once, even within its validity window
+	rows, err := cfg.db.UseTOTPStep(req.Context(), database.UseTOTPStepParams{
+		UserID:       totp.UserID,
+		LastUsedStep: sql.NullInt64{Int64: step, Valid: true},
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error recording TOTP use for user", "user_id", totp.UserID, "error", err)
+		return false
+	}
+	return rows == 1
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+)
+
+// base32 of the ASCII secret "12345678901234567890" from RFC 6238 appendix B
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	totp := auth.NewTOTP("Chirpy")
+	totp.Digits = 8
+
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		code, err := totp.Code(rfc6238Secret, totp.Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Errorf("error generating code: %v", err)
+		}
+		if code != expected {
+			t.Errorf("at %d expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestTOTPValidateWithClock(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := auth.NewTOTP("Chirpy")
+	totp.Now = func() time.Time { return now }
+
+	code, _ := totp.Code(rfc6238Secret, totp.Step(now.Add(-30*time.Second)))
+	step, ok := totp.Validate(rfc6238Secret, code)
+	if !ok {
+		t.Errorf("expected code from previous step to be accepted")
+	}
+	if step != totp.Step(now)-1 {
+		t.Errorf("expected matching step %d, got %d", totp.Step(now)-1, step)
+	}
+
+	code, _ = totp.Code(rfc6238Secret, totp.Step(now.Add(-90*time.Second)))
+	if _, ok := totp.Validate(rfc6238Secret, code); ok {
+		t.Errorf("expected code from three steps ago to be rejected")
+	}
+	if _, ok := totp.Validate(rfc6238Secret, "12345"); ok {
+		t.Errorf("expected short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	totp := auth.NewTOTP("Chirpy")
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil || len(secret) != 32 {
+		t.Errorf("unexpected secret %q (err %v)", secret, err)
+	}
+
+	uri := totp.URI("saul@bettercall.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:saul@bettercall.com?") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+secret) || !strings.Contains(uri, "issuer=Chirpy") {
+		t.Errorf("URI is missing secret or issuer: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.MakeRecoveryCodes(10)
+	if err != nil {
+		t.Errorf("error making recovery codes: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("unexpected recovery code %s", code)
+		}
+		seen[code] = true
+	}
+	if auth.NormalizeRecoveryCode("ABCDE-fghij") != auth.NormalizeRecoveryCode("abcde fghij") {
+		t.Errorf("recovery code normalization should ignore case and separators")
+	}
+}
+
+func TestMFAChallengeTokenIsNotAnAccessToken(t *testing.T) {
+	id := uuid.New()
+	secret := "my_secret"
+	token, err := auth.MakeMFAChallengeToken(id, secret, time.Minute)
+	if err != nil {
+		t.Errorf("error making MFA challenge token: %v", err)
+	}
+
+	if _, err := auth.ValidateJWT(token, secret); err == nil {
+		t.Errorf("MFA challenge token was accepted as an access token")
+	}
+	validatedId, err := auth.ValidateMFAChallengeToken(token, secret)
+	if err != nil || validatedId != id {
+		t.Errorf("failed to validate MFA challenge token: %v", err)
+	}
+
+	accessToken, _ := auth.MakeJWT(id, secret, time.Minute)
+	if _, err := auth.ValidateMFAChallengeToken(accessToken, secret); err == nil {
+		t.Errorf("access token was accepted as an MFA challenge token")
+	}
+}
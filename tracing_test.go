@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useInMemoryTracing routes spans to an exporter the test can inspect
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(t.Context())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return exporter
+}
+
+func TestMiddlewareTracing(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	cfg := &apiConfig{db: newTracedQueries(newFakeDB(nil).conn())}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/{userID}", func(wrt http.ResponseWriter, req *http.Request) {
+		if _, err := cfg.db.GetUser(req.Context(), uuid.New()); err != nil {
+			wrt.WriteHeader(404)
+			return
+		}
+		wrt.WriteHeader(200)
+	})
+	handler := middlewareTracing(mux, mux)
+
+	req := httptest.NewRequest("GET", "/api/users/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	query, server := spans[0], spans[1]
+
+	if server.Name != "GET /api/users/{userID}" || server.SpanKind != trace.SpanKindServer {
+		t.Errorf("unexpected server span %q (%v)", server.Name, server.SpanKind)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the caller's trace to continue, got trace %s", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("expected the caller's span as parent, got %s", got)
+	}
+	var status int64
+	for _, attr := range server.Attributes {
+		if attr.Key == "http.response.status_code" {
+			status = attr.Value.AsInt64()
+		}
+	}
+	if status != 404 {
+		t.Errorf("expected status 404 on the span, got %d", status)
+	}
+
+	if query.Name != "GetUser" || query.SpanKind != trace.SpanKindClient {
+		t.Errorf("unexpected query span %q (%v)", query.Name, query.SpanKind)
+	}
+	if query.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("expected the query span to be a child of the request span")
+	}
+}
+
+func TestTracedTransaction(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	db := newFakeDB(nil).conn()
+	cfg := &apiConfig{db: newTracedQueries(db), dbConn: db}
+
+	tx, err := db.BeginTx(t.Context(), nil)
+	if err != nil {
+		t.Fatalf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	cfg.withTx(tx).RevokeRefreshToken(t.Context(), "token")
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "RevokeRefreshToken" {
+		t.Errorf("expected a span for the query in the transaction, got %v", spans.Snapshots())
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"-- name: CreateChirp :one\nINSERT INTO chirps":    "CreateChirp",
+		"-- name: DeleteAllUsers :exec\nDELETE FROM users": "DeleteAllUsers",
+		"SELECT 1": "query",
+	}
+	for query, want := range tests {
+		if got := queryName(query); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
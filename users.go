@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/nfongster/chirpy/internal/database"
+)
+
+// handlerUpdateUser changes the email and password of the user whose access
+// token is in the request.
+func (cfg *apiConfig) handlerUpdateUser(wrt http.ResponseWriter, req *http.Request) {
+	// Check JWT first
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+
+	// Get new email and password
+	decoder := json.NewDecoder(req.Body)
+	params := userParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if !validateEmail(params.Email) {
+		wrt.WriteHeader(400)
+		wrt.Write([]byte("Invalid email address!"))
+		return
+	}
+	if params.Password == "" {
+		wrt.WriteHeader(400)
+		wrt.Write([]byte("No password was supplied!"))
+		return
+	}
+	if !cfg.checkPasswordPolicy(wrt, params.Password, params.Email) {
+		return
+	}
+
+	// Hash the new password
+	hashedPassword, err := cfg.hashers.Hash(params.Password)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error hashing password", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	current, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		wrt.WriteHeader(401)
+		return
+	}
+	// Update user info in DB
+	user, err := cfg.db.UpdateUser(req.Context(), database.UpdateUserParams{
+		ID:             userId,
+		Email:          params.Email,
+		HashedPassword: hashedPassword,
+	})
+	// Send response
+	if errors.Is(err, sql.ErrNoRows) {
+		// The account was deleted since the token was checked
+		wrt.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		wrt.WriteHeader(500)
+		return
+	}
+	// Changing the email clears the verified flag, so the new address must be confirmed
+	if user.Email != current.Email {
+		if err := cfg.sendVerificationEmail(req.Context(), user); err != nil {
+			slog.ErrorContext(req.Context(), "Error sending verification email", "email", user.Email, "error", err)
+		}
+	}
+
+	dat, err := json.Marshal(User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error marshalling JSON", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.WriteHeader(200)
+	wrt.Write(dat)
+}
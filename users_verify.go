@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/mailer"
+)
+
+const verificationTokenTTL = 24 * time.Hour
+
+// sendVerificationEmail issues a new single-use verification token for the user
+// and mails it to their address.  Only the token's hash is persisted.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return fmt.Errorf("error creating verification token: %w", err)
+	}
+
+	_, err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(verificationTokenTTL),
+		Email:     user.Email,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving verification token: %w", err)
+	}
+
+	body := fmt.Sprintf("Welcome to Chirpy!\n\nConfirm your email address by sending this token to POST /api/users/verify:\n\n%s\n", token)
+	body += fmt.Sprintf("\nThe token expires in %v.\n", verificationTokenTTL)
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy account",
+		Body:    body,
+	})
+}
+
+func (cfg *apiConfig) handlerVerifyUser(wrt http.ResponseWriter, req *http.Request) {
+	decoder := json.NewDecoder(req.Body)
+	params := verifyParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.Token == "" {
+		respondWithError(wrt, 400, "No token was supplied")
+		return
+	}
+
+	// Spending the token and verifying the address stand or fall together
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error starting transaction", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.withTx(tx)
+
+	// Consuming the token is a single UPDATE, so a token can't be redeemed twice
+	token, err := qtx.UseEmailVerificationToken(req.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(wrt, 400, "Token is invalid, expired or already used")
+		return
+	}
+
+	// A token for an address the user has since changed away from proves nothing
+	user, err := qtx.MarkUserEmailVerified(req.Context(), database.MarkUserEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 400, "Token is invalid, expired or already used")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error marking user as verified", "user_id", token.UserID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(req.Context(), "Error committing email verification", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	respondWithJSON(wrt, 200, User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/database"
+	"github.com/nfongster/chirpy/internal/webhook"
+)
+
+const (
+	webhookEventUserCreated = "user.created"
+
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts failures in a row give up on a delivery; with the
+	// backoff below that's about 4 hours after the first
+	webhookMaxAttempts = 8
+	webhookRetryBase   = time.Minute
+	webhookRetryMax    = 2 * time.Hour
+	// webhookConcurrency deliveries are sent at once, so one slow receiver
+	// doesn't hold up the rest
+	webhookConcurrency        = 4
+	webhookDeliveriesPageSize = 50
+	maxWebhookErrorLength     = 500
+)
+
+var webhookEventTypes = []string{
+	chirpEventCreated,
+	chirpEventDeleted,
+	webhookEventUserCreated,
+}
+
+// queueWebhookEvent queues deliveries of an event to every webhook subscribed
+// to it that may see it: all-users webhooks, and those of subject, the user
+// the event is about.  Run it in the transaction that makes the change, so
+// the event is queued if and only if the change commits.
+func queueWebhookEvent(ctx context.Context, q *database.Queries, eventType string, subject uuid.NullUUID, data any) error {
+	eventID := uuid.New()
+	payload, err := json.Marshal(webhookPayload{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = q.QueueWebhookDeliveries(ctx, database.QueueWebhookDeliveriesParams{
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   string(payload),
+		SubjectID: subject,
+	})
+	return err
+}
+
+// processWebhookDeliveries sends the deliveries that are due every interval.
+// Deliveries are claimed from the database, so any number of instances can
+// share the work.
+func (cfg *apiConfig) processWebhookDeliveries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg.sendDueWebhookDeliveries()
+	}
+}
+
+// sendDueWebhookDeliveries sends deliveries until none are due
+func (cfg *apiConfig) sendDueWebhookDeliveries() {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, webhookConcurrency)
+	for {
+		slots <- struct{}{}
+		delivery, err := cfg.db.ClaimWebhookDelivery(context.Background())
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				slog.Error("Error claiming webhook delivery", "error", err)
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			cfg.sendWebhookDelivery(delivery)
+		}()
+	}
+}
+
+// sendWebhookDelivery makes one attempt at a claimed delivery and records
+// the outcome, scheduling a retry if it failed and attempts remain.
+func (cfg *apiConfig) sendWebhookDelivery(delivery database.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	hook, err := cfg.db.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		// Deleting a webhook deletes its deliveries too
+		slog.ErrorContext(ctx, "Error getting webhook for delivery", "delivery_id", delivery.ID, "error", err)
+		return
+	}
+
+	start := time.Now()
+	status, err := cfg.postWebhook(ctx, hook, delivery)
+	attempt := database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID:     delivery.ID,
+		ResponseStatus: sql.NullInt32{Int32: int32(status), Valid: status != 0},
+		DurationMs:     int32(time.Since(start).Milliseconds()),
+	}
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > maxWebhookErrorLength {
+			msg = msg[:maxWebhookErrorLength]
+		}
+		attempt.Error = sql.NullString{String: msg, Valid: true}
+	}
+	if err := cfg.db.CreateWebhookDeliveryAttempt(ctx, attempt); err != nil {
+		slog.ErrorContext(ctx, "Error logging webhook delivery attempt", "delivery_id", delivery.ID, "error", err)
+	}
+
+	switch {
+	case err == nil:
+		err = cfg.db.CompleteWebhookDelivery(ctx, database.CompleteWebhookDeliveryParams{
+			ID:                 delivery.ID,
+			Status:             "succeeded",
+			LastResponseStatus: attempt.ResponseStatus,
+		})
+		webhookDeliveries.WithLabelValues("succeeded").Inc()
+	case delivery.Attempts >= webhookMaxAttempts:
+		slog.WarnContext(ctx, "Giving up on webhook delivery", "delivery_id", delivery.ID, "webhook_id", hook.ID, "attempts", delivery.Attempts)
+		err = cfg.db.CompleteWebhookDelivery(ctx, database.CompleteWebhookDeliveryParams{
+			ID:                 delivery.ID,
+			Status:             "failed",
+			LastResponseStatus: attempt.ResponseStatus,
+			LastError:          attempt.Error,
+		})
+		webhookDeliveries.WithLabelValues("failed").Inc()
+	default:
+		err = cfg.db.RetryWebhookDelivery(ctx, database.RetryWebhookDeliveryParams{
+			ID:                 delivery.ID,
+			NextAttemptAt:      time.Now().Add(webhook.Backoff(int(delivery.Attempts), webhookRetryBase, webhookRetryMax)),
+			LastResponseStatus: attempt.ResponseStatus,
+			LastError:          attempt.Error,
+		})
+		webhookDeliveries.WithLabelValues("retried").Inc()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// postWebhook sends a delivery, returning the response status if there was a
+// response.  Anything but a 2xx response is an error.
+func (cfg *apiConfig) postWebhook(ctx context.Context, hook database.Webhook, delivery database.WebhookDelivery) (int, error) {
+	client := cfg.userWebhookClient
+	if hook.AllUsers {
+		client = cfg.webhookClient
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks")
+	req.Header.Set(webhook.EventHeader, delivery.EventType)
+	req.Header.Set(webhook.DeliveryHeader, delivery.ID.String())
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(hook.Secret, now, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Draining a little lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// pruneWebhookDeliveries periodically deletes the logs of old deliveries.
+func (cfg *apiConfig) pruneWebhookDeliveries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cfg.db.DeleteOldWebhookDeliveries(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error deleting old webhook deliveries", "error", err)
+		}
+		cancel()
+	}
+}
+
+func webhookResponse(hook database.Webhook) Webhook {
+	return Webhook{
+		ID:         hook.ID,
+		CreatedAt:  hook.CreatedAt,
+		URL:        hook.Url,
+		EventTypes: hook.EventTypes,
+		AllUsers:   hook.AllUsers,
+	}
+}
+
+func webhookDeliveryResponse(delivery database.WebhookDelivery) WebhookDelivery {
+	resp := WebhookDelivery{
+		ID:        delivery.ID,
+		CreatedAt: delivery.CreatedAt,
+		EventID:   delivery.EventID,
+		EventType: delivery.EventType,
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		LastError: delivery.LastError.String,
+	}
+	if delivery.Status == "pending" {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.CompletedAt.Valid {
+		resp.CompletedAt = &delivery.CompletedAt.Time
+	}
+	if delivery.LastResponseStatus.Valid {
+		resp.LastResponseStatus = &delivery.LastResponseStatus.Int32
+	}
+	return resp
+}
+
+// validWebhookURL accepts absolute http and https URLs, and for webhooks only
+// sent the user's own events, just https.
+func validWebhookURL(raw string, allUsers bool) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return false
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && allUsers)
+}
+
+// webhookForRequest authenticates the request and looks up the {webhookID}
+// it acts on, writing the error response itself on failure.  Users manage
+// the webhooks they created, and admins every all-users webhook.
+func (cfg *apiConfig) webhookForRequest(wrt http.ResponseWriter, req *http.Request) (database.Webhook, bool) {
+	user, ok := cfg.authorize(wrt, req, roleUser, roleModerator, roleAdmin)
+	if !ok {
+		return database.Webhook{}, false
+	}
+	webhookID, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("webhookID")))
+		return database.Webhook{}, false
+	}
+	hook, err := cfg.db.GetWebhook(req.Context(), webhookID)
+	if err == nil && hook.CreatedBy != user.ID && !(hook.AllUsers && user.Role == roleAdmin) {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 404, fmt.Sprintf("No webhook found for id %v", webhookID))
+		return database.Webhook{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting webhook", "webhook_id", webhookID, "error", err)
+		wrt.WriteHeader(500)
+		return database.Webhook{}, false
+	}
+	return hook, true
+}
+
+// handlerCreateWebhook registers a webhook for the user's own events, or for
+// an admin, optionally everyone's.
+func (cfg *apiConfig) handlerCreateWebhook(wrt http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.authorize(wrt, req, roleUser, roleModerator, roleAdmin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := webhookParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		slog.ErrorContext(req.Context(), "Error decoding parameters", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	if params.AllUsers && user.Role != roleAdmin {
+		respondWithError(wrt, 403, "Only admins can receive every user's events")
+		return
+	}
+	if !validWebhookURL(params.URL, params.AllUsers) {
+		respondWithError(wrt, 400, "Invalid webhook URL")
+		return
+	}
+	if len(params.EventTypes) == 0 {
+		respondWithError(wrt, 400, "No event types were supplied")
+		return
+	}
+	for _, eventType := range params.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			respondWithError(wrt, 400, fmt.Sprintf("Unknown event type %s", eventType))
+			return
+		}
+	}
+
+	secret, err := webhook.MakeSecret()
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error creating webhook secret", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	hook, err := cfg.db.CreateWebhook(req.Context(), database.CreateWebhookParams{
+		CreatedBy:  user.ID,
+		AllUsers:   params.AllUsers,
+		Url:        params.URL,
+		Secret:     secret,
+		EventTypes: slices.Compact(slices.Sorted(slices.Values(params.EventTypes))),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error saving webhook", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// The secret is only ever returned here
+	resp := webhookResponse(hook)
+	resp.Secret = secret
+	respondWithJSON(wrt, 201, resp)
+}
+
+// handlerListWebhooks lists the user's webhooks, and for admins every
+// all-users webhook
+func (cfg *apiConfig) handlerListWebhooks(wrt http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.authorize(wrt, req, roleUser, roleModerator, roleAdmin)
+	if !ok {
+		return
+	}
+	hooks, err := cfg.db.ListWebhooks(req.Context(), database.ListWebhooksParams{
+		UserID:          user.ID,
+		IncludeAllUsers: user.Role == roleAdmin,
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing webhooks", "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	resp := make([]Webhook, len(hooks))
+	for i, hook := range hooks {
+		resp[i] = webhookResponse(hook)
+	}
+	respondWithJSON(wrt, 200, resp)
+}
+
+// handlerDeleteWebhook removes a webhook along with its queue and logs
+func (cfg *apiConfig) handlerDeleteWebhook(wrt http.ResponseWriter, req *http.Request) {
+	hook, ok := cfg.webhookForRequest(wrt, req)
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeleteWebhook(req.Context(), hook.ID); err != nil {
+		slog.ErrorContext(req.Context(), "Error deleting webhook", "webhook_id", hook.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerListWebhookDeliveries(wrt http.ResponseWriter, req *http.Request) {
+	hook, ok := cfg.webhookForRequest(wrt, req)
+	if !ok {
+		return
+	}
+	page := 1
+	if p := req.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			respondWithError(wrt, 400, fmt.Sprintf("Invalid page %q", p))
+			return
+		}
+		page = n
+	}
+
+	deliveries, err := cfg.db.ListWebhookDeliveries(req.Context(), database.ListWebhookDeliveriesParams{
+		WebhookID: hook.ID,
+		Limit:     webhookDeliveriesPageSize,
+		Offset:    int32((page - 1) * webhookDeliveriesPageSize),
+	})
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing webhook deliveries", "webhook_id", hook.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	resp := make([]WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		resp[i] = webhookDeliveryResponse(delivery)
+	}
+	respondWithJSON(wrt, 200, resp)
+}
+
+// webhookDeliveryForRequest looks up the {deliveryID} of the webhook a
+// request acts on, writing the error response itself on failure
+func (cfg *apiConfig) webhookDeliveryForRequest(wrt http.ResponseWriter, req *http.Request, hook database.Webhook) (database.WebhookDelivery, bool) {
+	deliveryID, err := uuid.Parse(req.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(wrt, 400, fmt.Sprintf("Could not parse %v into a uuid", req.PathValue("deliveryID")))
+		return database.WebhookDelivery{}, false
+	}
+	delivery, err := cfg.db.GetWebhookDelivery(req.Context(), database.GetWebhookDeliveryParams{
+		ID:        deliveryID,
+		WebhookID: hook.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(wrt, 404, fmt.Sprintf("No delivery found for id %v", deliveryID))
+		return database.WebhookDelivery{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error getting webhook delivery", "delivery_id", deliveryID, "error", err)
+		wrt.WriteHeader(500)
+		return database.WebhookDelivery{}, false
+	}
+	return delivery, true
+}
+
+// handlerGetWebhookDelivery shows a delivery with its payload and the log of
+// every attempt at it
+func (cfg *apiConfig) handlerGetWebhookDelivery(wrt http.ResponseWriter, req *http.Request) {
+	hook, ok := cfg.webhookForRequest(wrt, req)
+	if !ok {
+		return
+	}
+	delivery, ok := cfg.webhookDeliveryForRequest(wrt, req, hook)
+	if !ok {
+		return
+	}
+	attempts, err := cfg.db.ListWebhookDeliveryAttempts(req.Context(), delivery.ID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing webhook delivery attempts", "delivery_id", delivery.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	resp := webhookDeliveryResponse(delivery)
+	resp.Payload = json.RawMessage(delivery.Payload)
+	resp.AttemptLog = make([]WebhookDeliveryAttempt, len(attempts))
+	for i, attempt := range attempts {
+		resp.AttemptLog[i] = WebhookDeliveryAttempt{
+			AttemptedAt: attempt.AttemptedAt,
+			Error:       attempt.Error.String,
+			DurationMs:  attempt.DurationMs,
+		}
+		if attempt.ResponseStatus.Valid {
+			resp.AttemptLog[i].ResponseStatus = &attempt.ResponseStatus.Int32
+		}
+	}
+	respondWithJSON(wrt, 200, resp)
+}
+
+// handlerRedeliverWebhook queues a delivery to be sent again, as a new
+// delivery of the same event
+func (cfg *apiConfig) handlerRedeliverWebhook(wrt http.ResponseWriter, req *http.Request) {
+	hook, ok := cfg.webhookForRequest(wrt, req)
+	if !ok {
+		return
+	}
+	delivery, ok := cfg.webhookDeliveryForRequest(wrt, req, hook)
+	if !ok {
+		return
+	}
+	redelivery, err := cfg.db.RedeliverWebhookDelivery(req.Context(), delivery.ID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error queueing webhook redelivery", "delivery_id", delivery.ID, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+	wrt.Header().Set("Location", fmt.Sprintf("/api/webhooks/%s/deliveries/%s", hook.ID, redelivery.ID))
+	respondWithJSON(wrt, 202, webhookDeliveryResponse(redelivery))
+}
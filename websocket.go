@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/stream"
+)
+
+const (
+	// wsReadLimit caps the size of a client message; they're all small
+	wsReadLimit = 4 << 10
+	// wsMaxChannels caps how many channels one connection can subscribe to
+	wsMaxChannels = 50
+	// wsWriteTimeout is how long a client can take to accept a message.  One
+	// that is slower falls behind and is disconnected.
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	// wsAuthRecheck is how often a connection checks its user hasn't been
+	// suspended or deleted since it connected
+	wsAuthRecheck        = time.Minute
+	wsAuthRecheckTimeout = 5 * time.Second
+
+	// Close codes in the range reserved for applications, so clients can tell
+	// a connection needing a new token from one that shouldn't come back
+	wsStatusTokenExpired  websocket.StatusCode = 4001
+	wsStatusAccessRevoked websocket.StatusCode = 4003
+)
+
+// Reasons a connection closed, for chirpy_websocket_disconnects_total
+const (
+	wsDisconnectClient   = "client"
+	wsDisconnectExpired  = "token_expired"
+	wsDisconnectRevoked  = "revoked"
+	wsDisconnectSlow     = "slow"
+	wsDisconnectShutdown = "shutdown"
+	wsDisconnectError    = "error"
+)
+
+// wsSession is the state of one WebSocket connection
+type wsSession struct {
+	cfg    *apiConfig
+	conn   *websocket.Conn
+	userID uuid.UUID
+	// email is the user's address, which chirps mention them by
+	email string
+	// hidden are authors the user has blocked or muted, or who blocked them
+	hidden map[uuid.UUID]bool
+	// channels maps the subscribed channels to the chirps they carry
+	channels map[string]chirpStreamFilter
+}
+
+// handlerWebSocket upgrades to a WebSocket for a client authenticated with an
+// access token.  Over it the client subscribes to channels and is sent their
+// chirp events, until its token expires or its access is taken away.
+func (cfg *apiConfig) handlerWebSocket(wrt http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	user, err := cfg.db.GetUser(req.Context(), userId)
+	if err != nil {
+		respondWithAuthError(wrt, err)
+		return
+	}
+	tokenString, _ := auth.GetBearerToken(req.Header)
+	expiresAt, err := auth.TokenExpiry(tokenString)
+	if err != nil {
+		respondWithError(wrt, 401, "Access token has no expiry")
+		return
+	}
+	hidden, err := cfg.hiddenAuthors(req.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error listing hidden authors", "user_id", userId, "error", err)
+		wrt.WriteHeader(500)
+		return
+	}
+
+	// Accept writes its own response if the upgrade fails
+	conn, err := websocket.Accept(wrt, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsReadLimit)
+
+	websocketConnections.Inc()
+	defer websocketConnections.Dec()
+	session := &wsSession{
+		cfg:      cfg,
+		conn:     conn,
+		userID:   userId,
+		email:    user.Email,
+		hidden:   hidden,
+		channels: map[string]chirpStreamFilter{},
+	}
+	reason := session.serve(req.Context(), expiresAt)
+	websocketDisconnects.WithLabelValues(reason).Inc()
+}
+
+// serve runs the connection until it closes, returning why it did
+func (s *wsSession) serve(ctx context.Context, expiresAt time.Time) string {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribing up front means no events are missed between a subscribe
+	// message and the next event
+	sub, _, _ := s.cfg.chirpHub.Subscribe("")
+	defer sub.Close()
+
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	go s.read(ctx, messages, readErr)
+	go s.keepAlive(ctx)
+
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+	recheck := time.NewTicker(wsAuthRecheck)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case err := <-readErr:
+			status := websocket.CloseStatus(err)
+			if status != websocket.StatusNormalClosure && status != websocket.StatusGoingAway {
+				slog.DebugContext(ctx, "WebSocket read failed", "user_id", s.userID, "error", err)
+			}
+			return wsDisconnectClient
+		case dat := <-messages:
+			if err := s.handleMessage(ctx, dat); err != nil {
+				return writeFailure(err)
+			}
+		case event, open := <-sub.Events():
+			if !open {
+				if s.cfg.shuttingDown.Load() {
+					s.conn.Close(websocket.StatusGoingAway, "server is shutting down")
+					return wsDisconnectShutdown
+				}
+				s.conn.Close(websocket.StatusTryAgainLater, "connection fell behind")
+				return wsDisconnectSlow
+			}
+			if err := s.sendEvent(ctx, event); err != nil {
+				return writeFailure(err)
+			}
+		case <-expiry.C:
+			s.conn.Close(wsStatusTokenExpired, "access token expired")
+			return wsDisconnectExpired
+		case <-recheck.C:
+			if s.revoked(ctx) {
+				s.conn.Close(wsStatusAccessRevoked, "access revoked")
+				return wsDisconnectRevoked
+			}
+		}
+	}
+}
+
+// writeFailure is the disconnect reason for a failed write: a client that
+// doesn't accept a message in time is too slow to keep up.
+func writeFailure(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return wsDisconnectSlow
+	}
+	return wsDisconnectError
+}
+
+// read passes on the client's text messages until the connection fails
+func (s *wsSession) read(ctx context.Context, messages chan<- []byte, readErr chan<- error) {
+	for {
+		typ, dat, err := s.conn.Read(ctx)
+		if err != nil {
+			readErr <- err
+			return
+		}
+		if typ != websocket.MessageText {
+			s.conn.Close(websocket.StatusUnsupportedData, "messages must be JSON text")
+			continue
+		}
+		select {
+		case messages <- dat:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// keepAlive pings the client so dead connections are noticed and idle ones
+// aren't closed by proxies
+func (s *wsSession) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := s.conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				// Closing makes the read fail, which ends the session
+				s.conn.CloseNow()
+				return
+			}
+		}
+	}
+}
+
+// handleMessage carries out a client's subscribe or unsubscribe request
+func (s *wsSession) handleMessage(ctx context.Context, dat []byte) error {
+	var msg wsClientMessage
+	if err := json.Unmarshal(dat, &msg); err != nil {
+		return s.write(ctx, wsServerMessage{Type: "error", Error: "Couldn't decode message"})
+	}
+	channel, filter, err := parseWSChannel(msg.Channel, s.email)
+	if err != nil {
+		return s.write(ctx, wsServerMessage{Type: "error", Channel: msg.Channel, Error: err.Error()})
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		if _, ok := s.channels[channel]; !ok && len(s.channels) >= wsMaxChannels {
+			return s.write(ctx, wsServerMessage{
+				Type:    "error",
+				Channel: channel,
+				Error:   fmt.Sprintf("Can't subscribe to more than %d channels", wsMaxChannels),
+			})
+		}
+		s.channels[channel] = filter
+		return s.write(ctx, wsServerMessage{Type: "subscribed", Channel: channel})
+	case "unsubscribe":
+		delete(s.channels, channel)
+		return s.write(ctx, wsServerMessage{Type: "unsubscribed", Channel: channel})
+	default:
+		return s.write(ctx, wsServerMessage{Type: "error", Error: "Unknown message type"})
+	}
+}
+
+// parseWSChannel checks a channel name, returning it in canonical form with
+// the filter for its chirps.  The channels are "home", every chirp the user
+// can see, "user:<id>", one author's chirps, and "mentions", chirps that
+// mention the user at email.
+func parseWSChannel(name, email string) (string, chirpStreamFilter, error) {
+	if name == "home" {
+		return name, chirpStreamFilter{}, nil
+	}
+	if s, ok := strings.CutPrefix(name, "user:"); ok {
+		authorID, err := uuid.Parse(s)
+		if err != nil {
+			return "", chirpStreamFilter{}, fmt.Errorf("Could not parse %v into a uuid", s)
+		}
+		return "user:" + authorID.String(), chirpStreamFilter{author: uuid.NullUUID{UUID: authorID, Valid: true}}, nil
+	}
+	if name == "mentions" {
+		return name, chirpStreamFilter{mentioned: email}, nil
+	}
+	return "", chirpStreamFilter{}, errors.New("Unknown channel")
+}
+
+// sendEvent sends an event once on each subscribed channel it belongs to
+func (s *wsSession) sendEvent(ctx context.Context, event stream.Event[Chirp]) error {
+	channels := make([]string, 0, len(s.channels))
+	for channel, filter := range s.channels {
+		filter.hidden = s.hidden
+		if filter.matches(event.Data) {
+			channels = append(channels, channel)
+		}
+	}
+	slices.Sort(channels)
+	for _, channel := range channels {
+		err := s.write(ctx, wsServerMessage{
+			Type:    "event",
+			Channel: channel,
+			Event:   event.Type,
+			ID:      event.ID,
+			Data:    &event.Data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *wsSession) write(ctx context.Context, msg wsServerMessage) error {
+	dat, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	if err := s.conn.Write(ctx, websocket.MessageText, dat); err != nil {
+		return err
+	}
+	websocketMessagesSent.Inc()
+	return nil
+}
+
+// revoked reports whether the user has been deleted or suspended since they
+// connected.  Access tokens can't be revoked individually, so this is what
+// takes one away early.  The user's address, blocks and mutes are refreshed
+// too.
+func (s *wsSession) revoked(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, wsAuthRecheckTimeout)
+	defer cancel()
+
+	user, err := s.cfg.db.GetUser(ctx, s.userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	} else if err != nil {
+		// Don't disconnect everyone over a database hiccup
+		slog.ErrorContext(ctx, "Error checking WebSocket user", "user_id", s.userID, "error", err)
+		return false
+	}
+	// Follow a change of address, so mentions by the new one are sent
+	s.email = user.Email
+	if filter, ok := s.channels["mentions"]; ok {
+		filter.mentioned = user.Email
+		s.channels["mentions"] = filter
+	}
+	var suspended *suspendedError
+	if err := s.cfg.checkSuspension(ctx, s.userID); errors.As(err, &suspended) {
+		return true
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error checking WebSocket user suspension", "user_id", s.userID, "error", err)
+		return false
+	}
+
+	if hidden, err := s.cfg.hiddenAuthors(ctx, s.userID); err != nil {
+		slog.ErrorContext(ctx, "Error listing hidden authors", "user_id", s.userID, "error", err)
+	} else {
+		s.hidden = hidden
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+	"github.com/nfongster/chirpy/internal/auth"
+	"github.com/nfongster/chirpy/internal/stream"
+)
+
+// dialWebSocket connects to the handler as a user holding a token valid for
+// expiresIn
+func dialWebSocket(t *testing.T, cfg *apiConfig, expiresIn time.Duration) (*websocket.Conn, context.Context) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(cfg.handlerWebSocket))
+	t.Cleanup(server.Close)
+
+	token, err := auth.MakeJWT(uuid.New(), cfg.secret, expiresIn)
+	if err != nil {
+		t.Fatalf("error making token: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	t.Cleanup(cancel)
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"Bearer " + token}},
+	})
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn, ctx
+}
+
+func readWSMessage(t *testing.T, ctx context.Context, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+	var msg wsServerMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatalf("error reading message: %v", err)
+	}
+	return msg
+}
+
+func TestWebSocketChannels(t *testing.T) {
+	cfg := &apiConfig{
+		db:       newActiveUserQueries(),
+		secret:   "secret",
+		chirpHub: stream.NewHub[Chirp](10, 10),
+	}
+	conn, ctx := dialWebSocket(t, cfg, time.Hour)
+
+	saul, kim := uuid.New(), uuid.New()
+	wsjson.Write(ctx, conn, wsClientMessage{Type: "subscribe", Channel: "user:" + strings.ToUpper(kim.String())})
+	if msg := readWSMessage(t, ctx, conn); msg.Type != "subscribed" || msg.Channel != "user:"+kim.String() {
+		t.Fatalf("expected the canonical channel to be subscribed, got %+v", msg)
+	}
+	wsjson.Write(ctx, conn, wsClientMessage{Type: "subscribe", Channel: "mentions"})
+	if msg := readWSMessage(t, ctx, conn); msg.Type != "subscribed" || msg.Channel != "mentions" {
+		t.Fatalf("expected mentions to be subscribed, got %+v", msg)
+	}
+
+	cfg.chirpHub.Publish(chirpEvent(1, saul, "Not on the channel"))
+	cfg.chirpHub.Publish(chirpEvent(2, kim, "On the channel"))
+	msg := readWSMessage(t, ctx, conn)
+	if msg.Type != "event" || msg.ID != "2" || msg.Event != chirpEventCreated || msg.Data == nil || msg.Data.Body != "On the channel" {
+		t.Errorf("unexpected event %+v", msg)
+	}
+	// The connected user is saul@bettercall.com
+	cfg.chirpHub.Publish(chirpEvent(3, saul, "Call @kim@wexlermcgill.com"))
+	cfg.chirpHub.Publish(chirpEvent(4, saul, "Better call @saul@bettercall.com!"))
+	if msg := readWSMessage(t, ctx, conn); msg.Channel != "mentions" || msg.ID != "4" {
+		t.Errorf("expected the mention on the mentions channel, got %+v", msg)
+	}
+
+	wsjson.Write(ctx, conn, wsClientMessage{Type: "unsubscribe", Channel: "mentions"})
+	readWSMessage(t, ctx, conn)
+
+	wsjson.Write(ctx, conn, wsClientMessage{Type: "unsubscribe", Channel: "user:" + kim.String()})
+	if msg := readWSMessage(t, ctx, conn); msg.Type != "unsubscribed" {
+		t.Fatalf("expected the channel to be unsubscribed, got %+v", msg)
+	}
+	wsjson.Write(ctx, conn, wsClientMessage{Type: "subscribe", Channel: "home"})
+	readWSMessage(t, ctx, conn)
+	cfg.chirpHub.Publish(chirpEvent(5, kim, "Only on home now"))
+	if msg := readWSMessage(t, ctx, conn); msg.Channel != "home" || msg.ID != "5" {
+		t.Errorf("expected the event on home only, got %+v", msg)
+	}
+}
+
+func TestWebSocketTokenExpiry(t *testing.T) {
+	cfg := &apiConfig{
+		db:       newActiveUserQueries(),
+		secret:   "secret",
+		chirpHub: stream.NewHub[Chirp](10, 10),
+	}
+	// Expiry times are whole seconds, so this expires within two
+	conn, ctx := dialWebSocket(t, cfg, 2*time.Second)
+
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != wsStatusTokenExpired {
+		t.Errorf("expected close status %d when the token expired, got %v", wsStatusTokenExpired, err)
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	cfg := &apiConfig{
+		db:       newActiveUserQueries(),
+		secret:   "secret",
+		chirpHub: stream.NewHub[Chirp](10, 10),
+	}
+	conn, ctx := dialWebSocket(t, cfg, time.Hour)
+
+	for cfg.chirpHub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cfg.shuttingDown.Store(true)
+	cfg.chirpHub.Close()
+
+	_, _, err := conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+		t.Errorf("expected the connection to go away on shutdown, got %v", err)
+	}
+}
+
+func TestWebSocketRequiresToken(t *testing.T) {
+	cfg := &apiConfig{db: newEmptyQueries(), secret: "secret"}
+	rec := httptest.NewRecorder()
+	cfg.handlerWebSocket(rec, httptest.NewRequest("GET", "/api/ws", nil))
+	if rec.Code != 401 {
+		t.Errorf("expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+func TestWebSocketRevoked(t *testing.T) {
+	// The user can't be found, as if their account had been deleted
+	session := &wsSession{cfg: &apiConfig{db: newEmptyQueries()}, userID: uuid.New()}
+	if !session.revoked(t.Context()) {
+		t.Error("expected a deleted user's connection to be revoked")
+	}
+}